@@ -0,0 +1,103 @@
+package raid5
+
+import (
+	"sync"
+	"time"
+)
+
+//Array is the set of member directories that make up one raid5 array,
+//along with the policies that apply to the objects stored in it.  the
+//package level functions like CreateFile just use an Array with the
+//default policies.
+type Array struct {
+	//the in-process lock key for objects in this array, it stays the
+	//same when members are swapped out, see LockManager.lock()
+	lockKey string
+
+	//how hard WriteAndClose works to make new objects survive a crash
+	//before it returns.  individual files can override this with
+	//SetDurability().
+	Durability Durability
+
+	//how many I/O errors a member can have before it is marked failed
+	MaxErrors int
+
+	//when a data leg takes longer than this to read, ReadFile works it
+	//out from the other leg and parity instead, and does the same for
+	//the rest of that read without waiting on the slow member again.
+	//zero turns that off.
+	HedgeAfter time.Duration
+
+	//counters for monitoring, see stats.go
+	stats arrayStats
+
+	//protects everything below, see health.go and superblock.go
+	mu sync.Mutex
+	//d1, d2 and parity, in that order.  a member can be replaced by a
+	//spare, see spare.go.
+	dirs          []string
+	spares        []string
+	members       [3]memberHealth
+	rebuildCursor string
+	//the old directory of a member being replaced, see replace.go
+	retiring string
+	//only arrays from OpenArray and OpenArrayShared keep their state in
+	//superblocks
+	persistent bool
+	//from OpenArrayReadOnly or OpenArrayShared, no spares are promoted
+	//and no rebuilds started
+	passive bool
+	//the rebuild cursor has moved since the superblocks were written
+	unsaved    bool
+	id         string
+	generation uint64
+	//rebuilds and other long running work, see job.go
+	jobs    []*Job
+	running sync.WaitGroup
+	//set by Close, background jobs stop when they see it
+	closing bool
+}
+
+func NewArray(d1, d2, parity string) *Array {
+	return &Array{
+		lockKey:    d1,
+		dirs:       []string{d1, d2, parity},
+		Durability: DURABILITY_NONE,
+		MaxErrors:  MAX_MEMBER_ERRORS,
+	}
+}
+
+//Dirs returns the member directories, data first then parity.
+func (self *Array) Dirs() []string {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return append([]string{}, self.dirs...)
+}
+
+//Close stops the jobs running in the background (a rebuild carries on
+//from where it got to the next time the array is opened), writes out
+//the state of the array if it has changed since it was last written
+//and makes sure it's all on disk.  the array shouldn't be used after
+//this.
+func (self *Array) Close() error {
+	self.mu.Lock()
+	self.closing = true
+	self.mu.Unlock()
+	self.running.Wait()
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	var result error
+	if self.unsaved {
+		result = self.saveSuperblocks()
+	}
+	for i, dir := range self.dirs {
+		if self.members[i].state == MEMBER_FAILED {
+			continue
+		}
+		if err := syncDir(dir); err != nil && result == nil {
+			result = &MemberError{Member: i, Op: "sync directory", Err: err}
+		}
+	}
+	return result
+}
@@ -0,0 +1,393 @@
+//Package client talks to the ws server over HTTP, so programs don't have
+//to put together their own requests.  everything that comes back from
+//the server is checked against the md5 the server has for it, and
+//requests that fail in ways that might not happen next time are tried
+//again.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	//how many times a request is tried again before giving up
+	DEFAULT_RETRIES = 3
+	//how long to wait before the first retry, each one after that
+	//waits twice as long as the last
+	DEFAULT_BACKOFF = 100 * time.Millisecond
+)
+
+//Client is a connection to one ws server.  it's safe to use from any
+//number of goroutines, as long as the fields aren't changed while it's
+//in use.
+type Client struct {
+	//where the server is, like "http://localhost:8080"
+	URL string
+	//nil for http.DefaultClient
+	HTTP *http.Client
+	//see DEFAULT_RETRIES and DEFAULT_BACKOFF
+	Retries int
+	Backoff time.Duration
+}
+
+//New makes a client for the server at url, with the default retries.
+func New(url string) *Client {
+	return &Client{
+		URL:     strings.TrimSuffix(url, "/"),
+		Retries: DEFAULT_RETRIES,
+		Backoff: DEFAULT_BACKOFF,
+	}
+}
+
+//ObjectInfo is what Stat says about an object.
+type ObjectInfo struct {
+	Name string
+	Size int64
+	//md5 of the content
+	Hash []byte
+	//the Content-Type, Content-Encoding and Cache-Control the server
+	//sends it with, and any X-Raid5-Meta- headers it was stored with
+	Header http.Header
+}
+
+//the headers PutHeader can store with an object, along with any that
+//start with META_PREFIX
+var storedHeaders = []string{"Content-Type", "Content-Encoding", "Cache-Control"}
+
+const META_PREFIX = "X-Raid5-Meta-"
+
+//StatusError is a request that the server turned down.  errors.Is
+//finds os.ErrNotExist in one for an object that isn't there, and
+//os.ErrExist for a Put of a name that's already taken.
+type StatusError struct {
+	Method string
+	Name   string
+	Code   int
+	//what the server had to say about it
+	Message string
+}
+
+func (self *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s: %s", self.Method, self.Name, self.Code, http.StatusText(self.Code), self.Message)
+}
+
+func (self *StatusError) Unwrap() error {
+	switch self.Code {
+	case http.StatusNotFound:
+		return os.ErrNotExist
+	case http.StatusConflict:
+		return os.ErrExist
+	}
+	return nil
+}
+
+//ErrNoHash means the server didn't say what the md5 of an object is,
+//so there's nothing to check it against.
+var ErrNoHash = errors.New("server did not send the object's hash")
+
+//ErrChecksum means what the server sent (or says it stored) doesn't
+//match the md5 it has for the object.
+var ErrChecksum = errors.New("data does not match its checksum")
+
+//Put stores everything in r as the named object.  names that are
+//already taken can't be reused, it fails with os.ErrExist.  the md5
+//the server says it stored has to match what was sent.  r is read
+//completely before anything is sent, so it can be sent again if it
+//has to be.
+func (self *Client) Put(ctx context.Context, name string, r io.Reader) error {
+	return self.PutHeader(ctx, name, r, nil)
+}
+
+//PutHeader is Put, but the server also keeps the headers in header that
+//it knows about (see ObjectInfo.Header) and sends them back with the
+//object.
+func (self *Client) PutHeader(ctx context.Context, name string, r io.Reader, header http.Header) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	sum := md5.Sum(data)
+	return self.retry(ctx, func(try int) error {
+		resp, err := self.send(ctx, http.MethodPut, name, data, header)
+		if errors.Is(err, os.ErrExist) && try > 0 {
+			//an earlier try may have worked without us hearing about it
+			if info, statErr := self.Stat(ctx, name); statErr == nil && bytes.Equal(info.Hash, sum[:]) {
+				return nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		stored, err := etagHash(resp)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(stored, sum[:]) {
+			return fmt.Errorf("%s: server stored something else: %w", name, ErrChecksum)
+		}
+		return nil
+	})
+}
+
+//Get reads the named object.  the reader returns ErrChecksum
+//instead of io.EOF if what came back doesn't match the object's md5.
+//only getting the object is tried again, not reading it.
+func (self *Client) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	var result io.ReadCloser
+	err := self.retry(ctx, func(int) error {
+		resp, err := self.send(ctx, http.MethodGet, name, nil, nil)
+		if err != nil {
+			return err
+		}
+		want, err := etagHash(resp)
+		if err != nil {
+			resp.Body.Close()
+			return err
+		}
+		result = &verifyingReader{body: resp.Body, name: name, sum: md5.New(), want: want}
+		return nil
+	})
+	return result, err
+}
+
+//GetRange reads length bytes of the named object starting at offset,
+//or everything from offset on if length is negative.  there's no md5
+//for part of an object, so unlike Get this isn't checked.
+func (self *Client) GetRange(ctx context.Context, name string, offset int64, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("%s: negative offset %d", name, offset)
+	}
+	if length == 0 {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	header := http.Header{}
+	if length < 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	} else {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+	var result io.ReadCloser
+	err := self.retry(ctx, func(int) error {
+		resp, err := self.send(ctx, http.MethodGet, name, nil, header)
+		if err != nil {
+			return err
+		}
+		result = resp.Body
+		if resp.StatusCode == http.StatusPartialContent {
+			return nil
+		}
+		//the server sent the whole thing, so skip to the part we want
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil && err != io.EOF {
+			resp.Body.Close()
+			return err
+		}
+		if length > 0 {
+			result = &limitedReadCloser{Reader: io.LimitReader(resp.Body, length), Closer: resp.Body}
+		}
+		return nil
+	})
+	return result, err
+}
+
+//Stat asks the server about the named object without reading it.
+func (self *Client) Stat(ctx context.Context, name string) (*ObjectInfo, error) {
+	var result *ObjectInfo
+	err := self.retry(ctx, func(int) error {
+		resp, err := self.send(ctx, http.MethodHead, name, nil, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		sum, err := etagHash(resp)
+		if err != nil {
+			return err
+		}
+		size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: bad Content-Length from server: %v", name, err)
+		}
+		result = &ObjectInfo{Name: name, Size: size, Hash: sum, Header: http.Header{}}
+		for key, values := range resp.Header {
+			if strings.HasPrefix(key, META_PREFIX) {
+				result.Header[key] = values
+			}
+		}
+		for _, key := range storedHeaders {
+			if v := resp.Header.Get(key); v != "" {
+				result.Header.Set(key, v)
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+//Delete removes the named object, it fails with os.ErrNotExist if it
+//isn't there.
+func (self *Client) Delete(ctx context.Context, name string) error {
+	return self.retry(ctx, func(try int) error {
+		resp, err := self.send(ctx, http.MethodDelete, name, nil, nil)
+		if errors.Is(err, os.ErrNotExist) && try > 0 {
+			return nil //an earlier try got it
+		}
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	})
+}
+
+//List returns the names of the objects that start with prefix, sorted.
+func (self *Client) List(ctx context.Context, prefix string) ([]string, error) {
+	//the server lists "directories", we narrow it down from there
+	dir := prefix[:strings.LastIndex(prefix, "/")+1]
+	var result []string
+	err := self.retry(ctx, func(int) error {
+		resp, err := self.send(ctx, http.MethodGet, dir, nil, nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		result = []string{}
+		for _, name := range strings.Split(string(body), "\n") {
+			if name != "" && strings.HasPrefix(name, prefix) {
+				result = append(result, name)
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+//try fn until it works, it fails in a way that trying again won't fix
+//or we run out of tries.  fn is told which try it is, from 0.
+func (self *Client) retry(ctx context.Context, fn func(try int) error) error {
+	wait := self.Backoff
+	for try := 0; ; try++ {
+		err := fn(try)
+		if err == nil || try >= self.Retries || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		wait *= 2
+	}
+}
+
+//the server having trouble and the network losing things are worth
+//another try, the server not liking the request isn't
+func retryable(err error) bool {
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.Code >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+//one try at a request for the named object.  anything but a 2xx comes
+//back as a *StatusError, with the body already closed.
+func (self *Client) send(ctx context.Context, method string, name string, body []byte, header http.Header) (*http.Response, error) {
+	var content io.Reader
+	if body != nil {
+		content = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, self.objectURL(name), content)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	//objects come back exactly as they were stored, the transport
+	//mustn't decompress one that was stored gzipped
+	req.Header.Set("Accept-Encoding", "identity")
+	httpClient := self.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &StatusError{Method: method, Name: name, Code: resp.StatusCode, Message: string(msg)}
+	}
+	return resp, nil
+}
+
+//names can have slashes in them, which have to stay slashes, but
+//everything in between gets escaped
+func (self *Client) objectURL(name string) string {
+	pieces := strings.Split(name, "/")
+	for i, piece := range pieces {
+		pieces[i] = url.PathEscape(piece)
+	}
+	return self.URL + "/raid5/" + strings.Join(pieces, "/")
+}
+
+//the server's ETag is the md5 of the object
+func etagHash(resp *http.Response) ([]byte, error) {
+	tag := resp.Header.Get("ETag")
+	if tag == "" {
+		return nil, ErrNoHash
+	}
+	sum, err := hex.DecodeString(strings.Trim(tag, `"`))
+	if err != nil || len(sum) != md5.Size {
+		return nil, fmt.Errorf("bad ETag %s: %w", tag, ErrNoHash)
+	}
+	return sum, nil
+}
+
+//checks what's read against the md5 it's supposed to have when it gets
+//to the end
+type verifyingReader struct {
+	body io.ReadCloser
+	name string
+	sum  hash.Hash
+	want []byte
+}
+
+func (self *verifyingReader) Read(p []byte) (int, error) {
+	n, err := self.body.Read(p)
+	self.sum.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(self.sum.Sum(nil), self.want) {
+		return n, fmt.Errorf("%s: %w", self.name, ErrChecksum)
+	}
+	return n, err
+}
+
+func (self *verifyingReader) Close() error {
+	return self.body.Close()
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
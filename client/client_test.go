@@ -0,0 +1,189 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//answers like ws does, from memory
+type fakeServer struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	headers map[string]http.Header
+	//how many requests to turn away with a 503 before answering
+	failures int
+	//flip a byte of what GETs send
+	corrupt bool
+}
+
+func (self *fakeServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.failures > 0 {
+		self.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	name := strings.TrimPrefix(req.URL.Path, "/raid5/")
+	data, ok := self.objects[name]
+	switch {
+	case req.Method == http.MethodGet && (name == "" || strings.HasSuffix(name, "/")):
+		names := []string{}
+		for n := range self.objects {
+			if strings.HasPrefix(n, name) {
+				names = append(names, n)
+			}
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			io.WriteString(w, n+"\n")
+		}
+	case req.Method == http.MethodPut:
+		if ok {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		data, _ = ioutil.ReadAll(req.Body)
+		self.objects[name] = data
+		self.headers[name] = http.Header{}
+		for key, values := range req.Header {
+			if key == "Content-Type" || strings.HasPrefix(key, META_PREFIX) {
+				self.headers[name][key] = values
+			}
+		}
+		sum := md5.Sum(data)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	case !ok:
+		w.WriteHeader(http.StatusNotFound)
+	case req.Method == http.MethodDelete:
+		delete(self.objects, name)
+	default:
+		for key, values := range self.headers[name] {
+			w.Header()[key] = values
+		}
+		sum := md5.Sum(data)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+		if self.corrupt {
+			data = append([]byte{data[0] ^ 1}, data[1:]...)
+		}
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(data))
+	}
+}
+
+func setupClient(t *testing.T) (*Client, *fakeServer, func()) {
+	fake := &fakeServer{objects: make(map[string][]byte), headers: make(map[string]http.Header)}
+	srv := httptest.NewServer(fake)
+	c := New(srv.URL)
+	c.Backoff = time.Millisecond
+	return c, fake, srv.Close
+}
+
+func TestRoundTrip(t *testing.T) {
+	c, _, done := setupClient(t)
+	defer done()
+	ctx := context.Background()
+
+	content := bytes.Repeat([]byte("over the wire "), 1000)
+	if err := c.Put(ctx, "some dir/obj?", bytes.NewReader(content)); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	if err := c.Put(ctx, "some dir/obj?", bytes.NewReader(content)); !errors.Is(err, os.ErrExist) {
+		t.Errorf("expected a second put to fail with ErrExist, got %v", err)
+	}
+	r, err := c.Get(ctx, "some dir/obj?")
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("wrong content read back: %v", err)
+	}
+	r, err = c.GetRange(ctx, "some dir/obj?", 14, 4)
+	if err != nil {
+		t.Fatalf("failed to get a range: %v", err)
+	}
+	got, _ = ioutil.ReadAll(r)
+	r.Close()
+	if string(got) != "over" {
+		t.Errorf("wrong range read back: %q", got)
+	}
+	info, err := c.Stat(ctx, "some dir/obj?")
+	if err != nil {
+		t.Fatalf("failed to stat: %v", err)
+	}
+	sum := md5.Sum(content)
+	if info.Size != int64(len(content)) || !bytes.Equal(info.Hash, sum[:]) {
+		t.Errorf("wrong size or hash: %+v", info)
+	}
+	names, err := c.List(ctx, "some dir/o")
+	if err != nil || len(names) != 1 || names[0] != "some dir/obj?" {
+		t.Errorf("wrong listing: %v %v", names, err)
+	}
+	if err := c.Delete(ctx, "some dir/obj?"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if _, err := c.Stat(ctx, "some dir/obj?"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the object to be gone, got %v", err)
+	}
+}
+
+func TestRetriesAndVerification(t *testing.T) {
+	c, fake, done := setupClient(t)
+	defer done()
+	ctx := context.Background()
+
+	fake.failures = DEFAULT_RETRIES
+	if err := c.Put(ctx, "flaky", strings.NewReader("eventually")); err != nil {
+		t.Fatalf("expected the put to be retried until it worked: %v", err)
+	}
+	fake.failures = DEFAULT_RETRIES + 1
+	var status *StatusError
+	if _, err := c.Stat(ctx, "flaky"); !errors.As(err, &status) || status.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected to run out of retries, got %v", err)
+	}
+
+	fake.failures = 0
+	fake.corrupt = true
+	r, err := c.Get(ctx, "flaky")
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); !errors.Is(err, ErrChecksum) {
+		t.Errorf("expected a checksum error, got %v", err)
+	}
+}
+
+func TestStoredHeaders(t *testing.T) {
+	c, _, done := setupClient(t)
+	defer done()
+	ctx := context.Background()
+
+	header := http.Header{}
+	header.Set("Content-Type", "text/x-test")
+	header.Set(META_PREFIX+"Owner", "somebody")
+	if err := c.PutHeader(ctx, "typed", strings.NewReader("text"), header); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	info, err := c.Stat(ctx, "typed")
+	if err != nil {
+		t.Fatalf("failed to stat: %v", err)
+	}
+	if info.Header.Get("Content-Type") != "text/x-test" || info.Header.Get(META_PREFIX+"Owner") != "somebody" {
+		t.Errorf("wrong headers: %v", info.Header)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/iansmith/raid5"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	members = flag.String("members", "", "the member directories of the array: d1,d2,parity")
+	server  = flag.String("server", "", "url of the admin address of a running ws server to ask instead, like http://localhost:8081")
+)
+
+//a command gets the arguments after its name, returning an error means
+//it didn't work
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"put":     {"name [file]: store a file (or stdin) as the named object", put},
+	"get":     {"name [file]: write the named object to a file (or stdout)", get},
+	"ls":      {"[prefix]: list the objects whose names start with prefix", ls},
+	"rm":      {"name...: remove objects", rm},
+	"stat":    {"name...: show the size, hash and members of objects", stat},
+	"verify":  {"[name...]: check objects (all of them by default) against their parity and hashes", verify},
+	"rebuild": {"-member N: refill a member from the other two", rebuild},
+	"replace": {"-member N -new /path: copy everything onto a new directory and retire the old member", replace},
+	"fsck":    {"[-repair]: look for debris left by crashes and members that disagree", fsck},
+}
+
+//how the command went, for scripts.  when more than one thing goes
+//wrong the biggest number wins.
+const (
+	EXIT_OK = iota
+	EXIT_ERROR
+	EXIT_USAGE
+	EXIT_NOT_FOUND
+	EXIT_DEGRADED
+	EXIT_CORRUPT
+)
+
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return EXIT_OK
+	case errors.As(err, new(usageError)):
+		return EXIT_USAGE
+	case errors.Is(err, raid5.ErrChecksum):
+		return EXIT_CORRUPT
+	case errors.Is(err, raid5.ErrNoQuorum):
+		return EXIT_CORRUPT
+	case errors.Is(err, raid5.ErrDegraded):
+		return EXIT_DEGRADED
+	case errors.Is(err, os.ErrNotExist):
+		return EXIT_NOT_FOUND
+	}
+	return EXIT_ERROR
+}
+
+//the array opened by openArray, closed on the way out
+var opened *raid5.Array
+
+//errors that are the user's fault get the usage message
+type usageError string
+
+func (self usageError) Error() string {
+	return string(self)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] command [command flags]\n\nflags:\n", filepath.Base(os.Args[0]))
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\ncommands:\n")
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s %s\n", name, commands[name].usage)
+	}
+}
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		return EXIT_USAGE
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+		usage()
+		return EXIT_USAGE
+	}
+	err := cmd.run(flag.Args()[1:])
+	if opened != nil {
+		if closeErr := opened.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", flag.Arg(0), err)
+	}
+	return exitCode(err)
+}
+
+//the array in the directories given with -members, as long as none of
+//it has gone missing (see raid5.CheckArray)
+func openArray() (*raid5.Array, error) {
+	dirs, err := memberDirs()
+	if err != nil {
+		return nil, err
+	}
+	if err := raid5.CheckArray(dirs[0], dirs[1], dirs[2]); err != nil {
+		return nil, err
+	}
+	array, err := raid5.OpenArray(dirs[0], dirs[1], dirs[2])
+	if err != nil {
+		return nil, err
+	}
+	opened = array
+	return array, nil
+}
+
+//openArray for the commands that only write objects.  a ws server may
+//have the array open, so no spares or rebuilds are started behind its
+//back and the superblocks are only written if a member fails on us.
+func openArrayShared() (*raid5.Array, error) {
+	dirs, err := memberDirs()
+	if err != nil {
+		return nil, err
+	}
+	if err := raid5.CheckArray(dirs[0], dirs[1], dirs[2]); err != nil {
+		return nil, err
+	}
+	array, err := raid5.OpenArrayShared(dirs[0], dirs[1], dirs[2])
+	if err != nil {
+		return nil, err
+	}
+	opened = array
+	return array, nil
+}
+
+//openArray for the commands that only look.  a ws server may have the
+//array open, so nothing is written to the superblocks and no rebuilds
+//or spares are started behind its back.
+func openArrayReadOnly() (*raid5.Array, error) {
+	dirs, err := memberDirs()
+	if err != nil {
+		return nil, err
+	}
+	array, err := raid5.OpenArrayReadOnly(dirs[0], dirs[1], dirs[2])
+	if err != nil {
+		return nil, err
+	}
+	opened = array
+	return array, nil
+}
+
+//the directories given with -members
+func memberDirs() ([]string, error) {
+	dirs := strings.Split(*members, ",")
+	if *members == "" || len(dirs) != 3 {
+		return nil, usageError("-members needs three directories, d1,d2,parity")
+	}
+	//the links in the members point at full paths, so relative ones
+	//won't do
+	for i, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		dirs[i] = abs
+	}
+	//a ws server can be working on the same members
+	raid5.Locks.LockFiles = true
+	return dirs, nil
+}
+
+//the commands that work on several objects keep going after an error,
+//this remembers all of them
+type worst struct {
+	errs []error
+}
+
+func (self *worst) add(err error) {
+	if err != nil {
+		self.errs = append(self.errs, err)
+	}
+}
+
+//nil if nothing went wrong.  if more than one thing did they are all
+//printed, and the worst of them decides the exit code.
+func (self *worst) result() error {
+	if len(self.errs) <= 1 {
+		return errors.Join(self.errs...)
+	}
+	var result error
+	for _, err := range self.errs {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		if result == nil || exitCode(err) > exitCode(result) {
+			result = err
+		}
+	}
+	return fmt.Errorf("%d problems, the worst was %w", len(self.errs), result)
+}
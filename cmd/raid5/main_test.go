@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"github.com/iansmith/raid5"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//a temp dir that goes away when the test is done
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "raid5")
+	if err != nil {
+		t.Fatalf("failed to make a temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+//an array in temp directories, with -members pointing at it
+func setupMembers(t *testing.T) []string {
+	dirs := []string{tempDir(t), tempDir(t), tempDir(t)}
+	array, err := raid5.OpenArray(dirs[0], dirs[1], dirs[2])
+	if err != nil {
+		t.Fatalf("failed to make the array: %v", err)
+	}
+	if err := array.Close(); err != nil {
+		t.Fatalf("failed to close the array: %v", err)
+	}
+	old := *members
+	*members = strings.Join(dirs, ",")
+	t.Cleanup(func() { *members = old })
+	return dirs
+}
+
+//run a command the way run does, closing whatever it opened
+func runCommand(t *testing.T, name string, args ...string) int {
+	err := commands[name].run(args)
+	if opened != nil {
+		if closeErr := opened.Close(); err == nil {
+			err = closeErr
+		}
+		opened = nil
+	}
+	return exitCode(err)
+}
+
+func TestGetCorruptLeg(t *testing.T) {
+	dirs := setupMembers(t)
+	content := bytes.Repeat([]byte("0123456789"), 3*raid5.BLOCK_SIZE/10)
+	in := filepath.Join(tempDir(t), "in")
+	if err := ioutil.WriteFile(in, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := runCommand(t, "put", "obj", in); code != EXIT_OK {
+		t.Fatalf("put exited with %d", code)
+	}
+	out := filepath.Join(tempDir(t), "out")
+	if code := runCommand(t, "get", "obj", out); code != EXIT_OK {
+		t.Fatalf("get exited with %d", code)
+	}
+	if got, _ := ioutil.ReadFile(out); !bytes.Equal(got, content) {
+		t.Fatalf("get wrote %d bytes that don't match", len(got))
+	}
+
+	//flip a byte in d1's leg, the read can't tell but the md5 can
+	leg, err := filepath.EvalSymlinks(filepath.Join(dirs[raid5.MEMBER_D1], "obj"))
+	if err != nil {
+		t.Fatalf("no leg in d1: %v", err)
+	}
+	f, err := os.OpenFile(leg, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 1)
+	f.ReadAt(b, 0)
+	b[0] ^= 0xff
+	f.WriteAt(b, 0)
+	f.Close()
+
+	if code := runCommand(t, "get", "obj", out); code != EXIT_CORRUPT {
+		t.Errorf("get of a corrupt object exited with %d, expected %d", code, EXIT_CORRUPT)
+	}
+}
+
+//a ws server may have the array open, put and rm mustn't write over
+//its superblocks
+func TestPutRmLeaveSuperblocks(t *testing.T) {
+	dirs := setupMembers(t)
+	superblocks := func() []string {
+		result := []string{}
+		for _, dir := range dirs {
+			raw, err := ioutil.ReadFile(filepath.Join(dir, raid5.RESERVED_DIR, "superblock"))
+			if err != nil {
+				t.Fatalf("failed to read superblock: %v", err)
+			}
+			result = append(result, string(raw))
+		}
+		return result
+	}
+	before := superblocks()
+	in := filepath.Join(tempDir(t), "in")
+	if err := ioutil.WriteFile(in, []byte("passing through"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := runCommand(t, "put", "obj", in); code != EXIT_OK {
+		t.Fatalf("put exited with %d", code)
+	}
+	if code := runCommand(t, "rm", "obj"); code != EXIT_OK {
+		t.Fatalf("rm exited with %d", code)
+	}
+	after := superblocks()
+	for i := range before {
+		if before[i] != after[i] {
+			t.Errorf("superblock of member %d was rewritten", i)
+		}
+	}
+}
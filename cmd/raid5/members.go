@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/iansmith/raid5"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+func replace(args []string) error {
+	fs := flag.NewFlagSet("replace", flag.ContinueOnError)
+	memberName := fs.String("member", "", "the member to replace, d1, d2, parity or its number")
+	dir := fs.String("new", "", "the directory to replace it with")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+	member, err := raid5.ParseMember(*memberName)
+	if err != nil {
+		return usageError(err.Error())
+	}
+	if *dir == "" {
+		return usageError("-new is required")
+	}
+	//the directory has to make sense to the server, not just to us
+	abs, err := filepath.Abs(*dir)
+	if err != nil {
+		return err
+	}
+
+	//the server keeps serving while it copies, so just ask it
+	if *server != "" {
+		resp, err := http.PostForm(strings.TrimSuffix(*server, "/")+"/admin/replace",
+			url.Values{"member": {*memberName}, "new": {abs}})
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		if resp.StatusCode != http.StatusAccepted {
+			return fmt.Errorf("server said %s: %s", resp.Status, body)
+		}
+		fmt.Printf("server is replacing member %s with %s\n", *memberName, abs)
+		return nil
+	}
+
+	array, err := openArray()
+	if err != nil {
+		return err
+	}
+	if err := array.ReplaceMember(member, abs); err != nil {
+		return err
+	}
+	array.Wait()
+	for _, job := range array.Jobs() {
+		if job.Kind != "rebuild" || job.Dir != abs {
+			continue
+		}
+		if job.Error != "" {
+			return fmt.Errorf("copied %d of %d objects: %s", job.Done, job.Total, job.Error)
+		}
+		fmt.Printf("replaced member %s with %s, %d objects (%d couldn't be copied)\n",
+			*memberName, abs, job.Total, job.Failed)
+	}
+	return nil
+}
+
+func rebuild(args []string) error {
+	fs := flag.NewFlagSet("rebuild", flag.ContinueOnError)
+	memberName := fs.String("member", "", "the member to rebuild, d1, d2, parity or its number")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+	member, err := raid5.ParseMember(*memberName)
+	if err != nil {
+		return usageError(err.Error())
+	}
+	array, err := openArray()
+	if err != nil {
+		return err
+	}
+	if err := array.Rebuild(member); err != nil {
+		return err
+	}
+	var job raid5.Job
+	for _, j := range array.Jobs() {
+		if j.Kind == "rebuild" && j.Member == member {
+			job = j
+		}
+	}
+	fmt.Printf("rebuilt member %s, %d objects (%d couldn't be rebuilt)\n", *memberName, job.Total, job.Failed)
+	return nil
+}
+
+func fsck(args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	repair := fs.Bool("repair", false, "move debris to lost+found and rebuild stale or missing legs")
+	if err := fs.Parse(args); err != nil {
+		return usageError(err.Error())
+	}
+	array, err := openArray()
+	if err != nil {
+		return err
+	}
+	report, err := array.Fsck(*repair)
+	if err != nil {
+		return err
+	}
+	for _, problem := range report.Problems {
+		fmt.Println(problem)
+	}
+	if report.LostAndFound != "" {
+		fmt.Printf("anything moved is in %s in its member\n", report.LostAndFound)
+	}
+	fmt.Printf("%d objects, %d problems, %d fixed\n", report.Objects, len(report.Problems),
+		len(report.Problems)-report.Unfixed())
+	if unfixed := report.Unfixed(); unfixed > 0 {
+		return fmt.Errorf("%d problems left alone", unfixed)
+	}
+	return nil
+}
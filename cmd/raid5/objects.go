@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"github.com/iansmith/raid5"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+//name [file]
+func put(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return usageError("put needs a name and optionally a file")
+	}
+	in := io.Reader(os.Stdin)
+	if len(args) == 2 && args[1] != "-" {
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	if err := raid5.ValidateName(args[0]); err != nil {
+		return usageError(err.Error())
+	}
+	array, err := openArrayShared()
+	if err != nil {
+		return err
+	}
+	obj, err := array.CreateFile(args[0])
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		obj.Abort()
+		return err
+	}
+	if len(data) == 0 {
+		return obj.Close()
+	}
+	_, _, err = obj.WriteAndClose(data)
+	return err
+}
+
+//name [file].  a degraded read still writes out the data, but says so.
+func get(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return usageError("get needs a name and optionally a file")
+	}
+	array, err := openArrayReadOnly()
+	if err != nil {
+		return err
+	}
+	obj, err := array.OpenFile(args[0])
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	out := io.Writer(os.Stdout)
+	if len(args) == 2 && args[1] != "-" {
+		f, err := os.Create(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	//the reads don't check the md5, only whole object reads do, so it's
+	//worked out as the data goes by
+	sum := md5.New()
+	if _, err := io.Copy(io.MultiWriter(out, sum), io.NewSectionReader(obj, 0, obj.Size())); err != nil {
+		return err
+	}
+	if obj.Hash() != nil && !bytes.Equal(sum.Sum(nil), obj.Hash()) {
+		return fmt.Errorf("%s: %w", args[0], raid5.ErrChecksum)
+	}
+	if missing := obj.Missing(); len(missing) > 0 {
+		return fmt.Errorf("%s: read without member %d: %w", args[0], missing[0], raid5.ErrDegraded)
+	}
+	return nil
+}
+
+//[prefix]
+func ls(args []string) error {
+	if len(args) > 1 {
+		return usageError("ls takes at most one prefix")
+	}
+	prefix := ""
+	if len(args) == 1 {
+		prefix = args[0]
+	}
+	array, err := openArrayReadOnly()
+	if err != nil {
+		return err
+	}
+	names, err := array.ListFiles(prefix)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+//name...
+func rm(args []string) error {
+	if len(args) == 0 {
+		return usageError("rm needs at least one name")
+	}
+	array, err := openArrayShared()
+	if err != nil {
+		return err
+	}
+	var result worst
+	for _, name := range args {
+		result.add(array.RemoveFile(name))
+	}
+	return result.result()
+}
+
+//name...
+func stat(args []string) error {
+	if len(args) == 0 {
+		return usageError("stat needs at least one name")
+	}
+	array, err := openArrayReadOnly()
+	if err != nil {
+		return err
+	}
+	var result worst
+	for _, name := range args {
+		obj, err := array.OpenFile(name)
+		if err != nil {
+			result.add(err)
+			continue
+		}
+		fmt.Printf("%s\n  size: %d\n  md5: %x\n", name, obj.Size(), obj.Hash())
+		if missing := obj.Missing(); len(missing) > 0 {
+			fmt.Printf("  missing member: %d\n", missing[0])
+			result.add(fmt.Errorf("%s: %w", name, raid5.ErrDegraded))
+		}
+		obj.Close()
+	}
+	return result.result()
+}
+
+//[name...], everything if there are none
+func verify(args []string) error {
+	array, err := openArrayReadOnly()
+	if err != nil {
+		return err
+	}
+	names := args
+	if len(names) == 0 {
+		if names, err = array.ListFiles(""); err != nil {
+			return err
+		}
+	}
+	var result worst
+	for _, name := range names {
+		result.add(array.Verify(name))
+	}
+	if len(result.errs) == 0 {
+		fmt.Printf("%d objects ok\n", len(names))
+	}
+	return result.result()
+}
@@ -0,0 +1,131 @@
+package raid5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCancelledWriteRollsBack(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	ctx, cancel := context.WithCancel(context.Background())
+	obj, err := array.CreateFileContext(ctx, "gone")
+	if err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	cancel()
+	content := bytes.Repeat([]byte("never mind "), BLOCK_SIZE)
+	if _, _, err := obj.WriteAndClose(content); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the write to be cancelled, got %v", err)
+	}
+	expectNothingLeft(t, d1, d2, parity)
+
+	//another context for the write wins over the create's
+	if _, err := array.CreateFileContext(ctx, "gone"); err == nil {
+		t.Fatalf("expected create with a cancelled context to fail")
+	}
+	obj, err = array.CreateFile("gone")
+	if err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	if _, _, err := obj.WriteAndCloseContext(ctx, content); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the write to be cancelled, got %v", err)
+	}
+	expectNothingLeft(t, d1, d2, parity)
+}
+
+func TestReadDeadline(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	content := bytes.Repeat([]byte("take your time "), BLOCK_SIZE/3)
+	writeTestObject(t, array, "slow", content)
+	delayReads(t, array, MEMBER_D1, 200*time.Millisecond)
+
+	obj, err := array.OpenFile("slow")
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer obj.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = obj.ReadFileContext(ctx, make([]byte, obj.Size()), 0)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the read to run out of time, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+		t.Errorf("read waited for the disk: %v", elapsed)
+	}
+	if m := array.Members()[MEMBER_D1]; m.Errors != 0 {
+		t.Errorf("running out of time isn't the member's fault: %+v", m)
+	}
+}
+
+func TestOpenGivesUpWaiting(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	writer, err := array.CreateFile("busy")
+	if err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := array.OpenFileContext(ctx, "busy"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected open to give up, got %v", err)
+	}
+	if _, _, err := writer.WriteAndClose([]byte("done now")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	//the lock the open gave up on doesn't get stuck
+	done := make(chan error)
+	go func() {
+		obj, err := array.CreateFile("busy")
+		if err == nil {
+			obj.Abort()
+		}
+		done <- err
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("lock is stuck")
+	}
+}
+
+func TestCancelDropsQueuedReads(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	content := bytes.Repeat([]byte("a long line "), QUEUE_DEPTH*BLOCK_SIZE/6)
+	writeTestObject(t, array, "queued", content)
+	delayReads(t, array, MEMBER_D1, 100*time.Millisecond)
+
+	obj, err := array.OpenFile("queued")
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := obj.ReadFileContext(ctx, make([]byte, obj.Size()), 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the read to run out of time, got %v", err)
+	}
+	//only the read the disk had already started is waited for
+	start := time.Now()
+	obj.Close()
+	if elapsed := time.Since(start); elapsed >= time.Duration(QUEUE_DEPTH/2)*100*time.Millisecond {
+		t.Errorf("reads queued behind the slow one weren't dropped: %v", elapsed)
+	}
+	if m := array.Members()[MEMBER_D1]; m.Errors != 0 {
+		t.Errorf("dropped reads aren't the member's fault: %+v", m)
+	}
+}
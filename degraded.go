@@ -0,0 +1,109 @@
+package raid5
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+//objects written without one of their legs are remembered by an empty
+//marker file, at the object's name, under .raid5/missing/<member> in
+//each of the members that did get written.  that way a rebuild can
+//find them without looking at every object in the array.
+func missingPath(dir string, member int, path string) string {
+	return filepath.Join(dir, RESERVED_DIR, "missing", memberNames[member], path)
+}
+
+//called once a file from CreateFile is safely written, for each member
+//that it had to do without
+func (self *raid5File) recordMissing() {
+	for member := range self.dirs {
+		if self.has(member) {
+			continue
+		}
+		if self.markMissing(member) {
+			log.Printf("wrote %s without member %s", self.startingName, memberNames[member])
+		}
+	}
+}
+
+//leave a marker saying member needs its leg of the object in all the
+//members that have theirs, false if none could be written
+func (self *raid5File) markMissing(member int) bool {
+	path := filepath.FromSlash(self.startingName)
+	recorded := 0
+	for i, dir := range self.dirs {
+		if !self.has(i) {
+			continue
+		}
+		marker := missingPath(dir, member, path)
+		err := os.MkdirAll(filepath.Dir(marker), 0755)
+		if err == nil {
+			var f *os.File
+			f, err = os.Create(marker)
+			if err == nil {
+				err = f.Close()
+			}
+		}
+		if err != nil {
+			log.Printf("unable to record that %s is missing from member %s in %s: %v",
+				self.startingName, memberNames[member], dir, err)
+			continue
+		}
+		recorded++
+	}
+	return recorded > 0
+}
+
+//the leg of name on member is back, the markers can go
+func (self *Array) clearMissing(name string, member int) {
+	path := filepath.FromSlash(name)
+	for i, dir := range self.Dirs() {
+		if i == member {
+			continue
+		}
+		marker := missingPath(dir, member, path)
+		if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+			log.Printf("unable to remove missing marker %s: %v", marker, err)
+		}
+	}
+}
+
+//Degraded returns the names of all the objects that are known to be
+//missing their leg on member, sorted.
+func (self *Array) Degraded(member int) ([]string, error) {
+	found := make(map[string]bool)
+	for i, dir := range self.Dirs() {
+		if i == member || !self.writable(i) {
+			continue
+		}
+		root := missingPath(dir, member, "")
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			found[filepath.ToSlash(rel)] = true
+			return nil
+		})
+		if err != nil {
+			return nil, self.memberError(i, "list missing", err)
+		}
+	}
+	result := []string{}
+	for name := range found {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
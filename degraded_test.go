@@ -0,0 +1,75 @@
+package raid5
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+//make a member directory unusable by putting a plain file where it was
+func breakMember(t *testing.T, dir string) {
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("failed to remove %s: %v", dir, err)
+	}
+	if err := ioutil.WriteFile(dir, []byte("not a directory"), 0644); err != nil {
+		t.Fatalf("failed to replace %s: %v", dir, err)
+	}
+}
+
+func fixMember(t *testing.T, dir string) {
+	if err := os.Remove(dir); err != nil {
+		t.Fatalf("failed to remove %s: %v", dir, err)
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("failed to recreate %s: %v", dir, err)
+	}
+}
+
+func TestDegradedWrite(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	breakMember(t, d2)
+
+	content := bytes.Repeat([]byte("half the disks, all the data "), BLOCK_SIZE/10)
+	writeTestObject(t, array, "nested/survivor", content)
+	writeTestObject(t, array, "plain", content[:10])
+
+	missing, err := array.Degraded(MEMBER_D2)
+	if err != nil {
+		t.Fatalf("failed to get degraded objects: %v", err)
+	}
+	if len(missing) != 2 || missing[0] != "nested/survivor" || missing[1] != "plain" {
+		t.Errorf("wrong objects recorded as missing d2: %v", missing)
+	}
+	if other, _ := array.Degraded(MEMBER_D1); len(other) != 0 {
+		t.Errorf("nothing should be missing from d1: %v", other)
+	}
+
+	//the disk comes back empty, parity covers for it
+	fixMember(t, d2)
+	if !bytes.Equal(readTestObject(t, array, "nested/survivor"), content) {
+		t.Errorf("wrong content read back for degraded object")
+	}
+}
+
+func TestDegradedWriteNeedsTwoMembers(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	breakMember(t, d1)
+	breakMember(t, parity)
+	_, err := array.CreateFile("doomed")
+	if !errors.Is(err, ErrArrayFailed) {
+		t.Errorf("expected array failed error, got %v", err)
+	}
+	var memberErr *MemberError
+	if !errors.As(err, &memberErr) {
+		t.Errorf("expected the member error to be in there too: %v", err)
+	}
+	expectNothingLeft(t, d2)
+}
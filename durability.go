@@ -0,0 +1,103 @@
+package raid5
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//Durability says what has to be on stable storage before WriteAndClose
+//(or Close, for an empty file) returns.  each level includes the ones
+//before it.
+type Durability int
+
+const (
+	//leave it to the OS, fastest but a crash can lose an object that
+	//we already said was written
+	DURABILITY_NONE Durability = iota
+	//fsync the member files before closing them
+	DURABILITY_FSYNC
+	//also fsync the directories holding the renamed files and the
+	//symlinks, without this the object can be there but unreachable
+	//after a crash
+	DURABILITY_FSYNC_DIRS
+)
+
+var durabilityNames = []string{"none", "fsync", "fsync-dirs"}
+
+func (self Durability) String() string {
+	if self < 0 || int(self) >= len(durabilityNames) {
+		return fmt.Sprintf("Durability(%d)", int(self))
+	}
+	return durabilityNames[self]
+}
+
+//ParseDurability turns one of the names from String() back into a
+//Durability, for use in config files, flags and headers.
+func ParseDurability(s string) (Durability, error) {
+	for i, name := range durabilityNames {
+		if strings.EqualFold(s, name) {
+			return Durability(i), nil
+		}
+	}
+	return DURABILITY_NONE, fmt.Errorf("unknown durability %q, expected one of %s",
+		s, strings.Join(durabilityNames, ", "))
+}
+
+//SetDurability overrides the array's policy for this one file, it has
+//to be called before WriteAndClose or Close to have any effect.
+func (self *raid5File) SetDurability(d Durability) {
+	self.durability = d
+}
+
+//called with all the writes flushed but before the files are closed
+func (self *raid5File) syncFiles() error {
+	if self.durability < DURABILITY_FSYNC {
+		return nil
+	}
+	for i, f := range []*os.File{self.f1, self.f2, self.parity} {
+		if f == nil {
+			continue
+		}
+		if err := f.Sync(); err != nil {
+			return self.memberError(i, "sync", err)
+		}
+	}
+	return nil
+}
+
+//fsync every directory from the one holding the object up to the
+//member directory itself, since CreateFile may have made some of them
+func (self *raid5File) syncDirs() error {
+	if self.durability < DURABILITY_FSYNC_DIRS {
+		return nil
+	}
+	path := filepath.FromSlash(self.startingName)
+	for i, dir := range self.dirs {
+		if !self.has(i) {
+			continue
+		}
+		for p := filepath.Dir(path); ; p = filepath.Dir(p) {
+			if err := syncDir(filepath.Join(dir, p)); err != nil {
+				return self.memberError(i, "sync directory", err)
+			}
+			if p == "." {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
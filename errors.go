@@ -0,0 +1,101 @@
+package raid5
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//everything the library returns can be checked with errors.Is against
+//one of these, the more detailed types below unwrap to them.
+var (
+	//the name of the file underlying an object doesn't decode properly,
+	//usually someone has been messing with the member directories
+	ErrBadMetadata = errors.New("bad metadata in underlying file name")
+	//same thing as ErrInvalidName, spelled like the other errors here
+	ErrBadName = ErrInvalidName
+	//a member accepted fewer bytes than we gave it without saying why
+	ErrShortWrite = errors.New("short write to member file")
+	//a member gave back fewer bytes than we asked for without saying why
+	ErrShortRead = errors.New("short read from member file")
+	//the legs of an object in different members aren't the same size
+	ErrWrongSize = errors.New("member files are the wrong size")
+	//blocks have to be BLOCK_SIZE long and BLOCK_SIZE has to be even
+	ErrBadBlockSize = errors.New("bad block size")
+	//more than one member is failed, so there's no way to store or
+	//reconstruct anything
+	ErrArrayFailed = errors.New("not enough working members in the array")
+	//what came back from the members isn't what was written
+	ErrChecksum = errors.New("data does not match its checksum")
+	//some members have been set up as part of an array and some
+	//haven't, see CheckArray
+	ErrHalfInitialized = errors.New("array is only partly initialized")
+	//an object is missing one of its legs, it can still be read but
+	//it's one failure away from being lost
+	ErrDegraded = errors.New("object is missing a member")
+	//the array has been closed, see Array.Close
+	ErrClosed = errors.New("array is closed")
+	//the members that have an object don't agree on what it is, and
+	//there aren't enough of them on any one side to tell who is right
+	ErrNoQuorum = errors.New("members disagree about an object")
+)
+
+//MetadataError says which underlying name failed to decode and why.
+type MetadataError struct {
+	Name   string
+	Reason string
+}
+
+func (self *MetadataError) Error() string {
+	return fmt.Sprintf("bad metadata in %q: %s", self.Name, self.Reason)
+}
+
+func (self *MetadataError) Unwrap() error {
+	return ErrBadMetadata
+}
+
+//the members of an array, as used in MemberError
+const (
+	MEMBER_D1 = iota
+	MEMBER_D2
+	MEMBER_PARITY
+)
+
+var memberNames = []string{"d1", "d2", "parity"}
+
+//ParseMember takes a member's name (d1, d2 or parity) or its number and
+//returns the number.
+func ParseMember(s string) (int, error) {
+	for i, name := range memberNames {
+		if strings.EqualFold(s, name) || s == strconv.Itoa(i) {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("unknown member %q, expected a number or one of %s",
+		s, strings.Join(memberNames, ", "))
+}
+
+//MemberName is the opposite of ParseMember.
+func MemberName(member int) string {
+	if member < 0 || member >= len(memberNames) {
+		return strconv.Itoa(member)
+	}
+	return memberNames[member]
+}
+
+//MemberError says which member of the array an I/O error happened on.
+//Err is what the OS (or the library) said went wrong.
+type MemberError struct {
+	Member int
+	Op     string
+	Err    error
+}
+
+func (self *MemberError) Error() string {
+	return fmt.Sprintf("%s on member %s: %v", self.Op, memberNames[self.Member], self.Err)
+}
+
+func (self *MemberError) Unwrap() error {
+	return self.Err
+}
@@ -1,14 +1,19 @@
 package raid5
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
-	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/iansmith/raid5/parity"
 )
 
 //Public API to this type is in upper case.
@@ -16,21 +21,53 @@ type raid5File struct {
 	f1, f2 *os.File
 	parity *os.File
 
+	//member directories, in the same order as the files above.  a nil
+	//file means that member is missing or isn't being used.
+	dirs []string
+	//the array the file belongs to, errors are reported to it
+	array *Array
+
 	startingName string
-	//after a WriteAndClose() this holds the underlying FS name
+	//after a WriteAndClose() this holds the underlying FS name, relative
+	//to the member directories
 	finalName string
 
 	expectedLen  int64
 	expectedHash []byte
 
+	//writer lock from CreateFile or reader lock from OpenFile, given
+	//back by Close()
+	lock *heldLock
+
+	//how hard to work at making a new file survive a crash, see
+	//durability.go
+	durability Durability
+
+	//set by CreateFile until the object is completely written, while
+	//it's true a failure removes everything, see rollback.go
+	creating bool
+	//writes give up when this is done, see CreateFileContext.  nil is
+	//the same as context.Background().
+	ctx context.Context
+
+	//see SetMetadata, metaLoaded is set once Metadata has read it
+	metadata   map[string]string
+	metaLoaded bool
+
+	//one per member, in the same order as the files, see queue.go
+	queues []*memberQueue
+	//wait for each member to finish before starting the next one, this
+	//is how it used to work and is mostly here for comparison
+	serialIO bool
+
 	//support for overriding in tests
 	blockWriter func([]byte) error
 	writer      func([]byte) (int64, []byte, error)
 }
 
-var (
-	WRONG_SIZE = errors.New("we are assuming that writes to a disk always read/write the full set of bytes")
-)
+//WRONG_SIZE is the old name for ErrWrongSize.  short reads are
+//ErrShortRead now.
+var WRONG_SIZE = ErrWrongSize
 
 const (
 	BLOCK_SIZE           = 0x10000
@@ -42,53 +79,187 @@ func (self *raid5File) Size() int64 {
 	return self.expectedLen
 }
 
+//Hash is the md5 of the object's content, nil for an empty object.
+func (self *raid5File) Hash() []byte {
+	return self.expectedHash
+}
+
+//Missing says which members the object was opened without, reads of it
+//are reconstructed from parity if it's one of the data members.
+func (self *raid5File) Missing() []int {
+	result := []int{}
+	for i := range self.dirs {
+		if !self.has(i) {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+//do we have the file for this member?
+func (self *raid5File) has(member int) bool {
+	return []*os.File{self.f1, self.f2, self.parity}[member] != nil
+}
+
 //create a file. directories are "out of band" information not really
 //part of the public api.  note that this will return an error if the
-//file already exists.
+//file already exists.  names may contain slashes, see keyPath().
 func CreateFile(dir1, dir2, parityDir, name string) (*raid5File, error) {
-	if BLOCK_SIZE%2 != 0 {
-		panic("bad block size! block size must be even!")
-	}
+	return NewArray(dir1, dir2, parityDir).CreateFile(name)
+}
 
-	_, err1 := os.Open(filepath.Join(dir1, name))
-	_, err2 := os.Open(filepath.Join(dir2, name))
-	_, err3 := os.Open(filepath.Join(parityDir, name))
+//CreateFile is like the function of the same name, but the new file
+//gets the array's policies.
+func (self *Array) CreateFile(name string) (*raid5File, error) {
+	return self.CreateFileContext(context.Background(), name)
+}
 
-	//should we be doing voting here?
-	if err1 == nil || err2 == nil || err3 == nil {
-		return nil, os.ErrExist
+//CreateFileContext is CreateFile, but it gives up waiting for somebody
+//else using the name when ctx is done.  the file keeps ctx, and if it
+//is done before WriteAndClose is finished the write stops at the next
+//stripe and is rolled back.
+func (self *Array) CreateFileContext(ctx context.Context, name string) (*raid5File, error) {
+	if BLOCK_SIZE%2 != 0 {
+		return nil, ErrBadBlockSize
+	}
+	path, err := keyPath(name)
+	if err != nil {
+		return nil, err
+	}
+	//the members we are going to write.  failed members are left out,
+	//and so is any member that gives us trouble creating the file, as
+	//long as there are still two to hold the data.  whatever gets left
+	//out is recorded so it can be fixed later, see degraded.go.
+	dirs := self.Dirs()
+	using := make([]bool, len(dirs))
+	for i := range dirs {
+		using[i] = self.writable(i)
+	}
+	var lastErr, conflictErr error
+	drop := func(member int, op string, err error) {
+		using[member] = false
+		if nameConflict(dirs[member], err) {
+			conflictErr = fmt.Errorf("%s: %w: %v", name, os.ErrExist, err)
+			return
+		}
+		lastErr = self.memberErrorIn(dirs[member], member, op, err)
+	}
+	enough := func() bool {
+		ct := 0
+		for _, ok := range using {
+			if ok {
+				ct++
+			}
+		}
+		return ct >= 2
+	}
+	tooFew := func() error {
+		//the name is the problem, not the array
+		if conflictErr != nil {
+			return conflictErr
+		}
+		if lastErr == nil {
+			return ErrArrayFailed
+		}
+		return fmt.Errorf("%w: %w", ErrArrayFailed, lastErr)
 	}
 
-	//should we be doing voting?
-	if err1 != nil || err2 != nil || err3 != nil {
-		if !os.IsNotExist(err1) || !os.IsNotExist(err2) || !os.IsNotExist(err3) {
-			return nil, err1 //maybe should panic?
+	for i, dir := range dirs {
+		if using[i] {
+			if err := makeKeyDir(dir, path); err != nil {
+				drop(i, "mkdir", err)
+			}
 		}
-		//we continue because we WANT there to be the three not exist
-		//errors
+	}
+	if !enough() {
+		return nil, tooFew()
 	}
 
-	f1, err := os.Create(filepath.Join(dir1, name))
+	//nobody else can create or open this name until we are done
+	lock, err := Locks.lockContext(ctx, self.lockKey, dirs, path, true)
 	if err != nil {
 		return nil, err
 	}
-	f2, err := os.Create(filepath.Join(dir2, name))
-	if err != nil {
-		f1.Close()
+	fail := func(err error) (*raid5File, error) {
+		lock.Unlock()
 		return nil, err
 	}
-	parity, err := os.Create(filepath.Join(parityDir, name))
-	if err != nil {
-		f1.Close()
-		f2.Close()
-		return nil, err
+
+	//a link with nothing at the other end still counts, creating
+	//through it would write wherever it points
+	var have []int
+	for i, dir := range dirs {
+		if !using[i] {
+			continue
+		}
+		_, err := os.Lstat(filepath.Join(dir, path))
+		switch {
+		case err == nil:
+			have = append(have, i)
+		case !os.IsNotExist(err):
+			drop(i, "open", err)
+		}
+	}
+
+	//the members vote on whether the name is taken.  if just one of them
+	//has something there it's left over from a crash (see Fsck), and the
+	//object is written without that member rather than on top of
+	//whatever it is.  the leftover loses the vote in OpenFile, and a
+	//rebuild replaces it.
+	if len(have) >= 2 {
+		return fail(os.ErrExist)
+	}
+	for _, i := range have {
+		log.Printf("%s: member %s has something left over under that name, writing without it", name, memberNames[i])
+		using[i] = false
+	}
+	if len(have) > 0 && !enough() {
+		return fail(os.ErrExist)
+	}
+
+	files := make([]*os.File, len(dirs))
+	for i, dir := range dirs {
+		if !using[i] {
+			continue
+		}
+		f, err := os.Create(filepath.Join(dir, path))
+		//a RemoveFile of another name in the same directory prunes it
+		//if it finds it empty, which it can be until we get here
+		if os.IsNotExist(err) {
+			if err = makeKeyDir(dir, path); err == nil {
+				f, err = os.Create(filepath.Join(dir, path))
+			}
+		}
+		if err != nil {
+			drop(i, "create", err)
+			continue
+		}
+		files[i] = f
+	}
+	//if we can't go on, the ones we already made have to go or they
+	//will stop anybody from trying again
+	if !enough() {
+		for _, made := range files {
+			if made != nil {
+				made.Close()
+				os.Remove(made.Name())
+			}
+		}
+		return fail(tooFew())
 	}
 	result := &raid5File{
 		startingName: name,
-		f1:           f1,
-		f2:           f2,
-		parity:       parity,
-	}
+		f1:           files[0],
+		f2:           files[1],
+		parity:       files[2],
+		array:        self,
+		dirs:         dirs,
+		lock:         lock,
+		durability:   self.Durability,
+		creating:     true,
+		ctx:          ctx,
+	}
+	result.startQueues()
 
 	result.writer = result.write
 	result.blockWriter = result.writeSingleBlock
@@ -96,48 +267,76 @@ func CreateFile(dir1, dir2, parityDir, name string) (*raid5File, error) {
 }
 
 //close a file, not clear how to return the erorrs.  we are going to
-//try to close all the files first and then deal with errors
+//try to close all the files first and then deal with errors.  this
+//also gives back the lock on the object.
 func (self *raid5File) Close() error {
-	e1 := self.f1.Close()
-	e2 := self.f2.Close()
-	e3 := self.parity.Close()
+	err := self.closeFiles()
+	if err == nil && self.creating {
+		err = self.writeMeta()
+	}
+	if err == nil {
+		err = self.syncDirs()
+	}
+	//an empty file we can't be sure of is no better than a broken one
+	if err != nil && self.creating {
+		self.rollback()
+	}
+	if err == nil && self.creating {
+		self.recordMissing()
+	}
+	self.creating = false
+	self.unlock()
+	return err
+}
 
-	for _, e := range []error{e1, e2, e3} {
-		if e != nil {
-			return e
+//files that are missing (after OpenFile on a damaged array) are nil
+func (self *raid5File) closeFiles() error {
+	//anything still queued has to finish first
+	result := self.stopQueues()
+	if result == nil {
+		result = self.syncFiles()
+	}
+	for i, f := range []*os.File{self.f1, self.f2, self.parity} {
+		if f == nil {
+			continue
+		}
+		if e := f.Close(); e != nil && result == nil {
+			result = self.memberError(i, "close", e)
 		}
 	}
-	return nil
+	return result
+}
+
+func (self *raid5File) unlock() {
+	if self.lock != nil {
+		self.lock.Unlock()
+	}
 }
 
 //write exactly one block
 func (self *raid5File) writeSingleBlock(data []byte) error {
 	if len(data) != BLOCK_SIZE {
-		panic("unexpected size of block in WriteBlock!")
+		return fmt.Errorf("block of %d bytes: %w", len(data), ErrBadBlockSize)
 	}
 
 	//compute parity via XOR
-	parity := make([]byte, HALF_BLOCK)
-	for i, _ := range parity {
-		parity[i] = data[i] ^ data[i+HALF_BLOCK]
-	}
-
-	for which, blob := range [][]byte{data[:HALF_BLOCK], data[HALF_BLOCK:], parity} {
-		var n int
-		var err error
-		switch which {
-		case 0:
-			n, err = self.f1.Write(blob)
-		case 1:
-			n, err = self.f2.Write(blob)
-		case 2:
-			n, err = self.parity.Write(blob)
-		}
-		if n != HALF_BLOCK || err != nil {
-			if err != nil {
+	parityBlock := make([]byte, HALF_BLOCK)
+	parity.XOR(parityBlock, data[:HALF_BLOCK], data[HALF_BLOCK:])
+
+	//the queues do the actual writing, so this returns before the data
+	//is on the disk.  a failure shows up on a later call or at flush().
+	for which, blob := range [][]byte{data[:HALF_BLOCK], data[HALF_BLOCK:], parityBlock} {
+		//this member is failed, it'll be rebuilt later
+		if self.queues[which] == nil {
+			continue
+		}
+		if err := self.queues[which].write(blob); err != nil {
+			return err
+		}
+		if self.serialIO {
+			if err := self.queues[which].flush(); err != nil {
 				return err
 			}
-			return WRONG_SIZE
 		}
 	}
 	//everything is ok
@@ -153,6 +352,9 @@ func (self *raid5File) write(data []byte) (int64, []byte, error) {
 	h := md5.New()
 
 	for curr < len(data) {
+		if err := self.ctxErr(); err != nil {
+			return 0, nil, err
+		}
 		//can we write a whole block?
 		if len(data)-curr > BLOCK_SIZE {
 			if err := self.blockWrite(data[curr : curr+BLOCK_SIZE]); err != nil {
@@ -179,47 +381,86 @@ func (self *raid5File) write(data []byte) (int64, []byte, error) {
 		}
 		curr += BLOCK_SIZE
 	}
+	//don't claim success until every member has all of it
+	if err := self.flush(); err != nil {
+		return 0, nil, err
+	}
 	return int64(len(data)), h.Sum(nil), nil
 }
 
+//WriteAndCloseContext is WriteAndClose with a different context than
+//the one the file was created with, see CreateFileContext.
+func (self *raid5File) WriteAndCloseContext(ctx context.Context, data []byte) (int64, []byte, error) {
+	self.ctx = ctx
+	return self.WriteAndClose(data)
+}
+
+//the reason to stop writing, if the file's context is done
+func (self *raid5File) ctxErr() error {
+	if self.ctx == nil {
+		return nil
+	}
+	return self.ctx.Err()
+}
+
 //WriteAndClose defaults to calling the standard implementation, which is
 //just write.
 func (self *raid5File) WriteAndClose(data []byte) (int64, []byte, error) {
+	//the lock has to be held until the symlinks are there
+	defer self.unlock()
+	//anything that goes wrong from here on leaves nothing behind
+	fail := func(err error) (int64, []byte, error) {
+		self.rollback()
+		return 0, nil, err
+	}
+
 	l, h, err := self.writer(data)
+	if err == nil {
+		//last chance to change our minds before it's visible
+		err = self.ctxErr()
+	}
 	if err != nil {
-		return l, h, err // give up
+		return fail(err) // give up
+	}
+	if err := self.closeFiles(); err != nil {
+		return fail(err) //is there something more useful to do here?
 	}
-	if err := self.Close(); err != nil {
-		return 0, nil, err //is there something more useful to do here?
+	encoded, err := encodeMetadata(self.startingName, l, h)
+	if err != nil {
+		return fail(err)
 	}
-	self.finalName = encodeMetadata(self.startingName, l, h)
-	parentF1 := filepath.Dir(self.f1.Name())
-	parentF2 := filepath.Dir(self.f2.Name())
-	parentParity := filepath.Dir(self.parity.Name())
+	self.finalName = filepath.FromSlash(encoded)
 
 	//rename is pretty cheap in most systems
 	//we are ignoring collisions here because it's both irrelevant and
 	//in a better implementation it would be helpful to "unify" files
 	//with identical content (which is the case here)
-	if err := os.Rename(self.f1.Name(), filepath.Join(parentF1, self.finalName)); err != nil {
-		return 0, nil, err
-	}
-	if err := os.Rename(self.f2.Name(), filepath.Join(parentF2, self.finalName)); err != nil {
-		return 0, nil, err
+	for i, f := range []*os.File{self.f1, self.f2, self.parity} {
+		if f == nil {
+			continue
+		}
+		if err := os.Rename(f.Name(), filepath.Join(self.dirs[i], self.finalName)); err != nil {
+			return fail(self.memberError(i, "rename", err))
+		}
 	}
-	if err := os.Rename(self.parity.Name(), filepath.Join(parentParity, self.finalName)); err != nil {
-		return 0, nil, err
+	if err := self.writeMeta(); err != nil {
+		return fail(err)
 	}
 	//XXX NOT ATOMIC! CONCURRENCY PROBLEM!!
-	if err := os.Symlink(filepath.Join(parentF1, self.finalName), filepath.Join(parentF1, self.startingName)); err != nil {
-		return 0, nil, err
-	}
-	if err := os.Symlink(filepath.Join(parentF2, self.finalName), filepath.Join(parentF2, self.startingName)); err != nil {
-		return 0, nil, err
+	for i, dir := range self.dirs {
+		if !self.has(i) {
+			continue
+		}
+		if err := os.Symlink(filepath.Join(dir, self.finalName), filepath.Join(dir, filepath.FromSlash(self.startingName))); err != nil {
+			return fail(self.memberError(i, "symlink", err))
+		}
 	}
-	if err := os.Symlink(filepath.Join(parentParity, self.finalName), filepath.Join(parentParity, self.startingName)); err != nil {
-		return 0, nil, err
+	//the renames and symlinks aren't safe until their directories are
+	if err := self.syncDirs(); err != nil {
+		return fail(err)
 	}
+	self.recordMissing()
+	self.creating = false
 	return l, h, nil
 }
 
@@ -229,174 +470,450 @@ func (self *raid5File) blockWrite(data []byte) error {
 }
 
 //hide the length of the file and the md5hash in the name
-func encodeMetadata(name string, l int64, hash []byte) string {
+func encodeMetadata(name string, l int64, hash []byte) (string, error) {
 	if strings.Index(name, "$") != -1 {
-		panic("illegal character in filename!")
+		return "", &NameError{Name: name, Reason: "contains $"}
 	}
 	if name == "" {
-		panic("empty filenames are nonsense")
+		return "", &NameError{Name: name, Reason: "empty"}
 	}
-	return fmt.Sprintf("%s$%d$%x", name, l, hash)
+	return fmt.Sprintf("%s$%d$%x", name, l, hash), nil
 }
 
 //hide the length of the file and the md5hash in the name
-func decodeMetadata(name string) (string, int64, []byte) {
+func decodeMetadata(name string) (string, int64, []byte, error) {
+	bad := func(reason string) (string, int64, []byte, error) {
+		return "", 0, nil, &MetadataError{Name: name, Reason: reason}
+	}
 	pieces := strings.Split(name, "$")
 	if len(pieces) != 3 {
-		panic("badly encoded name sent to decode!")
+		return bad("expected three pieces separated by $")
 	}
 	l, err := strconv.ParseInt(pieces[1], 10, 64)
-	if err != nil {
-		panic("badly encoded name sent to decode (base 10 expected for length)")
+	if err != nil || l < 0 {
+		return bad("base 10 expected for length")
 	}
 	if len(pieces[2]) != HASH_LENGTH_IN_ASCII {
-		panic("badly encoded name sent to decode (base 16 hash is wrong length)")
+		return bad("base 16 hash is wrong length")
 	}
 	h := make([]byte, HASH_LENGTH_IN_ASCII/2)
 	for i := 0; i < len(pieces[2]); i += 2 {
-		b, err := strconv.ParseInt(pieces[2][i:i+2], 16, 64)
+		b, err := strconv.ParseUint(pieces[2][i:i+2], 16, 8)
 		if err != nil {
-			panic("badly encoded name sent to decode (base 16 hash)")
+			return bad("base 16 expected for hash")
 		}
-		h[i/2] = byte(b) //yes, this is safe
+		h[i/2] = byte(b)
 	}
-	return pieces[0], l, h
+	return pieces[0], l, h, nil
 }
 
 func OpenFile(d1, d2, parity, name string) (*raid5File, error) {
-	//try to open all three files
-	paths := []string{
-		filepath.Join(d1, name),
-		filepath.Join(d2, name),
-		filepath.Join(parity, name),
+	return NewArray(d1, d2, parity).OpenFile(name)
+}
+
+//OpenFile finds an existing object in the array, it only needs two of
+//the three members to have it.
+func (self *Array) OpenFile(name string) (*raid5File, error) {
+	return self.OpenFileContext(context.Background(), name)
+}
+
+//OpenFileContext is OpenFile, but it gives up waiting for somebody
+//writing the object when ctx is done.  see ReadFileContext for reading
+//with a context.
+func (self *Array) OpenFileContext(ctx context.Context, name string) (*raid5File, error) {
+	dirs := self.Dirs()
+	path, err := keyPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	//wait for anybody writing this object to finish
+	lock, err := Locks.lockContext(ctx, self.lockKey, dirs, path, false)
+	if err != nil {
+		return nil, err
 	}
 
-	f1, err1 := os.Open(paths[0])
-	f2, err2 := os.Open(paths[1])
-	p, err3 := os.Open(paths[2])
+	//try to open all three files.  members that are failed, or haven't
+	//been rebuilt this far yet, are left out without any fuss, we
+	//already know they are no good.  a member that is being replaced
+	//is read from the old directory until the copy gets this far.
+	paths := make([]string, len(dirs))
+	files := make([]*os.File, len(paths))
+	errs := make([]error, len(paths))
+	for i := range dirs {
+		if dir := self.memberDir(i, name); dir != "" {
+			dirs[i] = dir
+			paths[i] = filepath.Join(dir, path)
+			files[i], errs[i] = os.Open(paths[i])
+		}
+	}
 
+	result := &raid5File{
+		f1:           files[0],
+		f2:           files[1],
+		parity:       files[2],
+		array:        self,
+		dirs:         dirs,
+		startingName: name,
+		finalName:    path,
+		lock:         lock,
+	}
+	result.startQueues()
+	fail := func(err error) (*raid5File, error) {
+		result.Close()
+		return nil, err
+	}
+
+	//a leg that isn't there is just a degraded object, anything else
+	//(EIO, EACCES, a stale NFS handle...) is the member's fault and
+	//goes against its health.  either way parity can cover for one of
+	//them.
 	ct := 0
-	for i, err := range []error{err1, err2, err3} {
-		if err == nil {
+	var faultErr error
+	for i, err := range errs {
+		if files[i] != nil {
 			ct++
 			continue
 		}
-		if os.IsNotExist(err) {
+		if err == nil {
+			continue //skipped
+		}
+		if os.IsNotExist(err) || nameConflict(dirs[i], err) {
 			log.Printf("trying to recover from data missing in %s", paths[i])
-			continue // we can maybe tolerate this error
+			continue
 		}
-		//not clear: is this an error? if the disk is failing, it seems
-		//like it is, so we error here
-		return nil, err
+		faultErr = self.memberErrorIn(dirs[i], i, "open", err)
+		log.Printf("trying to recover from %v", faultErr)
 	}
 	if ct < 2 {
-		return nil, os.ErrNotExist
+		if faultErr != nil {
+			return fail(fmt.Errorf("%w: %w", ErrArrayFailed, faultErr))
+		}
+		return fail(&os.PathError{Op: "open", Path: name, Err: os.ErrNotExist})
+	}
+
+	//the members vote on what the object is, the length and hash are in
+	//the name of the leg each member's link points at.  a member that
+	//doesn't go along with the other two has a stale leg (from before a
+	//crash, say) and is left out just like one that's missing its leg.
+	legs := make([]string, len(files))
+	votes := make(map[string]int)
+	var legErr error
+	for i := range files {
+		if files[i] == nil {
+			continue
+		}
+		legs[i], err = legName(paths[i])
+		if err != nil {
+			legErr = err
+			legs[i] = ""
+			continue
+		}
+		votes[legs[i]]++
 	}
-	//figure out how long the file is and its expected hash, we can use
-	//one of the two parts
-	link := filepath.Join(d1, name)
-	if f1 == nil {
-		link = filepath.Join(d2, name)
+	leg, quorum := "", false
+	for l, ct := range votes {
+		if ct >= 2 {
+			leg, quorum = l, true
+		}
 	}
-	dest, linkErr := os.Readlink(link)
-	if linkErr != nil {
-		info, err := os.Stat(link)
-		if err != nil {
-			return nil, err
+	if !quorum {
+		if legErr != nil && len(votes) <= 1 {
+			return fail(legErr)
 		}
-		if info.Size() != 0 {
-			return nil, linkErr
+		return fail(fmt.Errorf("%s: %w", name, ErrNoQuorum))
+	}
+	var stale []int
+	for i := range files {
+		if files[i] == nil || legs[i] == leg {
+			continue
 		}
-		//zero sized file
-		return &raid5File{
-			f1:           f1,
-			f2:           f2,
-			parity:       p,
-			startingName: name,
-			finalName:    name,
-		}, nil
+		log.Printf("%s: member %s has a stale leg (%q), the others have %q", name, memberNames[i], legs[i], leg)
+		self.stats.staleLegs[i].Add(1)
+		result.queues[i].close()
+		result.queues[i] = nil
+		files[i].Close()
+		files[i] = nil
+		stale = append(stale, i)
+	}
+	//so that a rebuild or scrub puts it right
+	result.f1, result.f2, result.parity = files[0], files[1], files[2]
+	for _, i := range stale {
+		result.markMissing(i)
+	}
+	if leg == ZERO_LENGTH_LEG {
+		return result, nil
+	}
 
+	_, l, hsh, err := decodeMetadata(leg)
+	if err != nil {
+		return fail(err)
 	}
-	_, l, hsh := decodeMetadata(dest)
+	result.finalName = filepath.Join(filepath.Dir(path), leg)
+	result.expectedLen = l
+	result.expectedHash = hsh
+	return result, nil
+}
 
-	return &raid5File{
-		f1:           f1,
-		f2:           f2,
-		parity:       p,
-		startingName: name,
-		finalName:    dest,
-		expectedLen:  l,
-		expectedHash: hsh,
-	}, nil
+//what legName says about a zero length object, which is just a file
+//under the plain name with no link
+const ZERO_LENGTH_LEG = "."
+
+//the name of the leg a member's link points at.  only the last part of
+//the target has the metadata in it, the member directory itself could
+//have anything in its name.
+func legName(link string) (string, error) {
+	dest, linkErr := os.Readlink(link)
+	if linkErr == nil {
+		return filepath.Base(dest), nil
+	}
+	info, err := os.Lstat(link)
+	if err != nil {
+		return "", err
+	}
+	if info.Size() != 0 {
+		return "", linkErr
+	}
+	return ZERO_LENGTH_LEG, nil
 }
 
+//ReadFile fills out with the object starting offset bytes in, and
+//returns how many bytes that was.  it's short only when the object
+//ends first.  a read of the whole object is checked against the hash
+//it was written with.
 func (self *raid5File) ReadFile(out []byte, offset int64) (int64, error) {
+	return self.ReadFileContext(context.Background(), out, offset)
+}
 
-	//setup r1 and r2
-	r1 := self.f1
-	r2 := self.f2
-	usingParity := false
-	if r1 == nil || r2 == nil {
-		usingParity = true
-		//we know that the additional file we need is in p
-		if r1 == nil {
-			r1 = self.parity
-		} else {
-			r2 = self.parity
+//ReadAt is ReadFile for io.ReaderAt.
+func (self *raid5File) ReadAt(p []byte, off int64) (int, error) {
+	n, err := self.ReadFile(p, off)
+	if err == nil && n < int64(len(p)) {
+		err = io.EOF
+	}
+	return int(n), err
+}
+
+//ReadFileContext is ReadFile, but it stops between stripes once ctx is
+//done and returns ctx.Err().  reads already given to the members
+//finish in the background, the ones still waiting their turn are
+//dropped.
+func (self *raid5File) ReadFileContext(ctx context.Context, out []byte, offset int64) (int64, error) {
+	if offset < 0 {
+		return 0, fmt.Errorf("%s: negative offset %d", self.startingName, offset)
+	}
+	if offset >= self.expectedLen {
+		return 0, nil
+	}
+	n := int64(len(out))
+	if n > self.expectedLen-offset {
+		n = self.expectedLen - offset
+	}
+	//stripes are read whole, so a read that doesn't start at the
+	//beginning of one goes through a buffer that does
+	into := out[:n]
+	lead := offset % BLOCK_SIZE
+	if lead != 0 {
+		into = make([]byte, lead+n)
+	}
+
+	//a member that fails part way through is left out from there on, as
+	//long as the other two are there to cover for it.  the error has
+	//already been counted against the member by readStripes.
+	skip := -1
+	curr := 0
+	for {
+		var failed int
+		var err error
+		curr, failed, err = self.readStripes(ctx, into, offset-lead, curr, skip)
+		if err == nil {
+			break
+		}
+		if skip >= 0 || failed < 0 || self.legs() < 3 {
+			return 0, err
 		}
+		log.Printf("%s: reading the rest without member %s: %v", self.startingName, memberNames[failed], err)
+		skip = failed
 	}
 
-	//seek to the location requested
-	if _, err := r1.Seek(offset, 0); err != nil {
-		return 0, err
+	if lead != 0 {
+		copy(out, into[lead:])
 	}
-	if _, err := r2.Seek(offset, 0); err != nil {
-		return 0, err
+
+	//if we have the whole thing we can check it against the hash it
+	//was written with
+	if offset == 0 && n == self.expectedLen && self.expectedHash != nil {
+		sum := md5.Sum(out[:n])
+		if !bytes.Equal(sum[:], self.expectedHash) {
+			self.array.stats.checksumFailures.Add(1)
+			return 0, fmt.Errorf("%s: %w", self.startingName, ErrChecksum)
+		}
 	}
+	return n, nil
+}
 
-	curr := 0
-	data1 := make([]byte, HALF_BLOCK)
-	data2 := make([]byte, HALF_BLOCK)
+//how many members the file has a leg open in
+func (self *raid5File) legs() int {
+	ct := 0
+	for _, q := range self.queues {
+		if q != nil {
+			ct++
+		}
+	}
+	return ct
+}
+
+//one stripe's worth of reads from two legs
+type readStripe struct {
+	data1, data2 []byte
+	done1, done2 chan error
+	//where in the legs, and when it was asked for
+	pos    int64
+	issued time.Time
+	//the data member parity stood in for, -1 if it didn't
+	hedged int
+}
+
+//read the stripes of out from curr on without using member skip (-1
+//to use whatever there is).  out[0] is offset bytes into the object,
+//which has to be the start of a stripe, and out can't go past the end
+//of the object.  returns how far it got and, if a read failed, the
+//member it failed on.
+func (self *raid5File) readStripes(ctx context.Context, out []byte, offset int64, curr int, skip int) (int, int, error) {
+	use := func(member int) bool {
+		return member != skip && self.queues[member] != nil
+	}
+	//we read two legs, if one of them is parity the data leg that isn't
+	//there is worked out from the other two
+	var r1, r2 *memberQueue
+	rebuild := -1
+	switch {
+	case use(MEMBER_D1) && use(MEMBER_D2):
+		r1, r2 = self.queues[MEMBER_D1], self.queues[MEMBER_D2]
+	case use(MEMBER_D1) && use(MEMBER_PARITY):
+		r1, r2 = self.queues[MEMBER_D1], self.queues[MEMBER_PARITY]
+		rebuild = MEMBER_D2
+	case use(MEMBER_D2) && use(MEMBER_PARITY):
+		r1, r2 = self.queues[MEMBER_PARITY], self.queues[MEMBER_D2]
+		rebuild = MEMBER_D1
+	default:
+		return curr, -1, ErrArrayFailed
+	}
+	if rebuild >= 0 {
+		self.array.stats.degradedReads.Add(1)
+	}
+
+	//we keep up to QUEUE_DEPTH stripes worth of reads outstanding on
+	//both legs so the two disks are busy at the same time, and we are
+	//busy copying out the previous stripe while they work.  reads are
+	//done with ReadAt so any number of goroutines can be reading the
+	//same open object at once.
+	depth := QUEUE_DEPTH
+	if self.serialIO {
+		depth = 1
+	}
+	//with both data legs to read, parity can stand in for a slow one
+	hedging := self.array.HedgeAfter > 0 && rebuild < 0 && use(MEMBER_PARITY) && !self.serialIO
+	//so the reads still queued on a member we've hedged around can be
+	//called off
+	ctx1, cancel1 := context.WithCancel(ctx)
+	defer cancel1()
+	ctx2, cancel2 := context.WithCancel(ctx)
+	defer cancel2()
+	hedged := false
+	var inflight, free []*readStripe
+	//each stripe is BLOCK_SIZE of out and HALF_BLOCK of each leg
+	pos := (offset/BLOCK_SIZE + int64(curr/BLOCK_SIZE)) * HALF_BLOCK
+	issued := curr
+	issue := func() {
+		var s *readStripe
+		if len(free) > 0 {
+			s, free = free[len(free)-1], free[:len(free)-1]
+		} else {
+			s = &readStripe{data1: make([]byte, HALF_BLOCK), data2: make([]byte, HALF_BLOCK)}
+		}
+		s.pos, s.issued, s.hedged = pos, time.Now(), -1
+		s.done1 = r1.read(ctx1, s.data1, pos)
+		if self.serialIO {
+			//wait for it, but leave the result there for below
+			err := <-s.done1
+			s.done1 <- err
+		}
+		s.done2 = r2.read(ctx2, s.data2, pos)
+		pos += HALF_BLOCK
+		issued += BLOCK_SIZE
+		inflight = append(inflight, s)
+	}
 
 	for curr < len(out) {
-		n1, err := r1.Read(data1)
-		if err != nil {
-			return 0, err
+		if err := ctx.Err(); err != nil {
+			return curr, -1, err
 		}
-		n2, err := r2.Read(data2)
-		if err != nil {
-			return 0, err
+		for issued < len(out) && len(inflight) < depth {
+			issue()
 		}
-		if n1 != len(data1) {
-			return 0, WRONG_SIZE
+		s := inflight[0]
+		inflight = inflight[1:]
+		failed, err := self.awaitStripe(ctx, s, r1, r2, hedging)
+		if err != nil && failed < 0 {
+			return curr, -1, err
 		}
-		if n2 != len(data2) {
-			return 0, WRONG_SIZE
+		//a read dropped because ctx is done isn't the member's fault
+		if err != nil && ctx.Err() != nil {
+			return curr, -1, ctx.Err()
 		}
-		if usingParity {
-			for i := 0; i < HALF_BLOCK; i++ {
-				recovered := data1[i] ^ data2[i]
-				if self.f1 == nil {
-					data1[i] = recovered
-				} else {
-					data2[i] = recovered
-				}
+		if err != nil {
+			return curr, failed, self.memberError(failed, "read", err)
+		}
+		data1, data2 := s.data1, s.data2
+		if rebuild >= 0 {
+			//rebuild the missing leg in place
+			if rebuild == MEMBER_D1 {
+				parity.XOR(data1, data1, data2)
+			} else {
+				parity.XOR(data2, data1, data2)
+			}
+			self.array.stats.reconstructedBytes.Add(HALF_BLOCK)
+			if hedged {
+				self.array.stats.hedgedReads.Add(1)
 			}
 		}
-		//data is recovered if necessary, so copy it out
-		if int64(curr+HALF_BLOCK) > self.expectedLen {
-			copy(out[curr:], data1[:self.expectedLen-int64(curr)])
-		} else if int64(curr+BLOCK_SIZE) > self.expectedLen {
-			copy(out[curr:], data1)
-			copy(out[curr+HALF_BLOCK:], data2[:self.expectedLen-int64(curr+HALF_BLOCK)])
-		} else {
-			copy(out[curr:], data1)
+		//data is recovered if necessary, so copy it out.  the last
+		//stripe has padding that doesn't fit.
+		copy(out[curr:], data1)
+		if curr+HALF_BLOCK < len(out) {
 			copy(out[curr+HALF_BLOCK:], data2)
 		}
+		free = append(free, s)
 
 		//jump a block
 		curr += BLOCK_SIZE
-	}
 
-	return self.expectedLen, nil
+		//whatever is queued on the member we just hedged around is
+		//stuck behind the slow read, and once the queue is full every
+		//stripe would wait for it.  parity does the rest of the reading
+		//in its place, like a degraded read.
+		if s.hedged >= 0 {
+			hedging, hedged, rebuild = false, true, s.hedged
+			stand := self.queues[MEMBER_PARITY]
+			if s.hedged == r1.member {
+				cancel1()
+				r1, ctx1 = stand, ctx
+			} else {
+				cancel2()
+				r2, ctx2 = stand, ctx
+			}
+			//the called off reads (and the one still going) have the
+			//old buffers, parity gets new ones
+			for _, w := range inflight {
+				buf := make([]byte, HALF_BLOCK)
+				if stand == r1 {
+					w.data1, w.done1 = buf, r1.read(ctx1, buf, w.pos)
+				} else {
+					w.data2, w.done2 = buf, r2.read(ctx2, buf, w.pos)
+				}
+			}
+		}
+	}
+	return curr, -1, nil
 }
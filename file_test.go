@@ -1,6 +1,7 @@
 package raid5
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -10,7 +11,7 @@ import (
 	"testing"
 )
 
-func setupTestDirs(t *testing.T) (string, string, string) {
+func setupTestDirs(t testing.TB) (string, string, string) {
 	data1, err := ioutil.TempDir("", "raid5")
 	if err != nil {
 		t.Fatalf("creating data dir: %v", err)
@@ -26,7 +27,7 @@ func setupTestDirs(t *testing.T) (string, string, string) {
 	return data1, data2, parity
 }
 
-func destroyTestDirs(t *testing.T, data1, data2, parity string) {
+func destroyTestDirs(t testing.TB, data1, data2, parity string) {
 	for _, dir := range []string{data1, data2, parity} {
 		err := os.RemoveAll(dir)
 		if err != nil {
@@ -347,23 +348,72 @@ var exampleHash = []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
 
 //just to make sure our data never gets corrupted
 func TestDisallowedChars(t *testing.T) {
-	expectPanic(t, "foo$bar", 42, exampleHash)
-	expectPanic(t, "", 42, exampleHash)
+	expectBadName(t, "foo$bar", 42, exampleHash)
+	expectBadName(t, "", 42, exampleHash)
 }
 
-func expectPanic(t *testing.T, proposedName string, length int, hash []byte) {
-	defer func() {
-		r := recover()
-		if r == nil {
-			t.Errorf("expected to get a panic when encoding bad filename %s", proposedName)
+func expectBadName(t *testing.T, proposedName string, length int, hash []byte) {
+	_, err := encodeMetadata(proposedName, int64(length), hash)
+	if !errors.Is(err, ErrBadName) {
+		t.Errorf("expected to get an error when encoding bad filename %s: %v", proposedName, err)
+	}
+}
+
+func TestDecodeBadValues(t *testing.T) {
+	for _, raw := range []string{
+		"fleazil",
+		"fleazil$x$000102030405060708090a0b0c0d0e0f",
+		"fleazil$-1$000102030405060708090a0b0c0d0e0f",
+		"fleazil$3413$0001",
+		"fleazil$3413$zz0102030405060708090a0b0c0d0e0f",
+	} {
+		_, _, _, err := decodeMetadata(raw)
+		var metaErr *MetadataError
+		if !errors.As(err, &metaErr) || !errors.Is(err, ErrBadMetadata) {
+			t.Errorf("expected a metadata error decoding %s but got %v", raw, err)
 		}
-	}()
-	encodeMetadata(proposedName, int64(length), hash)
+	}
+}
+
+func TestOpenWithBadLink(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	//somebody has been messing around in the member directories
+	for _, dir := range []string{d1, d2, parity} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "garbage"), []byte("junk"), 0644); err != nil {
+			t.Fatalf("could not write garbage: %v", err)
+		}
+		if err := os.Symlink(filepath.Join(dir, "garbage"), filepath.Join(dir, "dud")); err != nil {
+			t.Fatalf("could not make symlink: %v", err)
+		}
+	}
+	_, err := OpenFile(d1, d2, parity, "dud")
+	if !errors.Is(err, ErrBadMetadata) {
+		t.Errorf("expected bad metadata error from open, got %v", err)
+	}
+}
+
+func TestWrongSizedBlock(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	result, err := CreateFile(d1, d2, parity, "stubby")
+	if err != nil {
+		t.Fatalf("failed to create files: %v", err)
+	}
+	defer result.Close()
+	if err := result.writeSingleBlock(make([]byte, 3)); !errors.Is(err, ErrBadBlockSize) {
+		t.Errorf("expected bad block size error, got %v", err)
+	}
 }
 
 func TestDecodeValues(t *testing.T) {
 	raw := "fleazil$3413$000102030405060708090a0b0c0d0e0f"
-	n, l, h := decodeMetadata(raw)
+	n, l, h, err := decodeMetadata(raw)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
 	if n != "fleazil" {
 		t.Errorf("bad name afetr decode: %v", n)
 	}
@@ -379,7 +429,10 @@ func TestDecodeValues(t *testing.T) {
 
 func TestEncodeValues(t *testing.T) {
 	fakeLen := rand.Int63n(0xffff)
-	encoded := encodeMetadata("fleazil", fakeLen, exampleHash)
+	encoded, err := encodeMetadata("fleazil", fakeLen, exampleHash)
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
 	pieces := strings.Split(encoded, "$")
 	if len(pieces) != 3 {
 		t.Fatalf("bad encoding %s", encoded)
@@ -484,3 +537,189 @@ func TestCanReadFileAfterDelete(t *testing.T) {
 	}
 
 }
+
+func TestSerialAndParallelAgree(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	buffer := make([]byte, 3*QUEUE_DEPTH*BLOCK_SIZE+rand.Intn(BLOCK_SIZE))
+	for i, _ := range buffer {
+		buffer[i] = byte(rand.Intn(256))
+	}
+
+	for _, serial := range []bool{true, false} {
+		name := fmt.Sprintf("serial_%v", serial)
+		result, err := CreateFile(d1, d2, parity, name)
+		if err != nil {
+			t.Fatalf("failed to create files: %v", err)
+		}
+		result.serialIO = serial
+		if _, _, err := result.WriteAndClose(buffer); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		//read it back the other way
+		obj, err := OpenFile(d1, d2, parity, name)
+		if err != nil {
+			t.Fatalf("failed to open: %v", err)
+		}
+		obj.serialIO = !serial
+		compare := make([]byte, len(buffer))
+		if _, err := obj.ReadFile(compare, 0); err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		obj.Close()
+		for i, _ := range buffer {
+			if buffer[i] != compare[i] {
+				t.Errorf("found mismatched bytes (serial write %v) at position %d", serial, i)
+				break
+			}
+		}
+	}
+}
+
+func TestWriteErrorFromQueue(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	result, err := CreateFile(d1, d2, parity, "doomed")
+	if err != nil {
+		t.Fatalf("failed to create files: %v", err)
+	}
+	//the disk under the second member "goes away"
+	result.f2.Close()
+	if _, _, err := result.WriteAndClose(make([]byte, 4*BLOCK_SIZE)); err == nil {
+		t.Errorf("expected the write error from the member queue to be returned")
+	}
+}
+
+//set RAID5_BENCH_DIRS to three directories separated by colons, ideally
+//on three different disks, to see what parallel I/O buys you on real
+//hardware.  by default everything is in the temp directory.
+func benchDirs(b *testing.B) (string, string, string, func()) {
+	if env := os.Getenv("RAID5_BENCH_DIRS"); env != "" {
+		dirs := strings.Split(env, ":")
+		if len(dirs) != 3 {
+			b.Fatalf("RAID5_BENCH_DIRS should have three directories in it")
+		}
+		var made []string
+		for i, dir := range dirs {
+			sub, err := ioutil.TempDir(dir, "raid5bench")
+			if err != nil {
+				b.Fatalf("creating bench dir: %v", err)
+			}
+			dirs[i] = sub
+			made = append(made, sub)
+		}
+		return dirs[0], dirs[1], dirs[2], func() { destroyTestDirs(b, made[0], made[1], made[2]) }
+	}
+	d1, d2, parity := setupTestDirs(b)
+	return d1, d2, parity, func() { destroyTestDirs(b, d1, d2, parity) }
+}
+
+const BENCH_SIZE = 128 * BLOCK_SIZE
+
+func benchmarkWrite(b *testing.B, serial bool) {
+	d1, d2, parity, cleanup := benchDirs(b)
+	defer cleanup()
+	buffer := make([]byte, BENCH_SIZE)
+	rand.Read(buffer)
+
+	b.SetBytes(BENCH_SIZE)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result, err := CreateFile(d1, d2, parity, fmt.Sprintf("bench%d", i))
+		if err != nil {
+			b.Fatalf("failed to create files: %v", err)
+		}
+		result.serialIO = serial
+		if _, _, err := result.WriteAndClose(buffer); err != nil {
+			b.Fatalf("failed to write: %v", err)
+		}
+	}
+}
+
+func benchmarkRead(b *testing.B, serial bool) {
+	d1, d2, parity, cleanup := benchDirs(b)
+	defer cleanup()
+	buffer := make([]byte, BENCH_SIZE)
+	rand.Read(buffer)
+	result, err := CreateFile(d1, d2, parity, "bench")
+	if err != nil {
+		b.Fatalf("failed to create files: %v", err)
+	}
+	if _, _, err := result.WriteAndClose(buffer); err != nil {
+		b.Fatalf("failed to write: %v", err)
+	}
+
+	b.SetBytes(BENCH_SIZE)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		obj, err := OpenFile(d1, d2, parity, "bench")
+		if err != nil {
+			b.Fatalf("failed to open: %v", err)
+		}
+		obj.serialIO = serial
+		if _, err := obj.ReadFile(buffer, 0); err != nil {
+			b.Fatalf("failed to read: %v", err)
+		}
+		obj.Close()
+	}
+}
+
+func BenchmarkWriteSerial(b *testing.B)   { benchmarkWrite(b, true) }
+func BenchmarkWriteParallel(b *testing.B) { benchmarkWrite(b, false) }
+func BenchmarkReadSerial(b *testing.B)    { benchmarkRead(b, true) }
+func BenchmarkReadParallel(b *testing.B)  { benchmarkRead(b, false) }
+
+func TestDurabilityLevels(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	array.Durability = DURABILITY_FSYNC
+	content := []byte("make it stick")
+	for _, d := range []Durability{DURABILITY_NONE, DURABILITY_FSYNC, DURABILITY_FSYNC_DIRS} {
+		name := "deep/down/" + d.String()
+		result, err := array.CreateFile(name)
+		if err != nil {
+			t.Fatalf("failed to create files: %v", err)
+		}
+		if result.durability != DURABILITY_FSYNC {
+			t.Errorf("file didn't get the array's durability: %v", result.durability)
+		}
+		result.SetDurability(d)
+		if _, _, err := result.WriteAndClose(content); err != nil {
+			t.Fatalf("failed to write with durability %v: %v", d, err)
+		}
+		obj, err := array.OpenFile(name)
+		if err != nil {
+			t.Fatalf("failed to open: %v", err)
+		}
+		if obj.Size() != int64(len(content)) {
+			t.Errorf("wrong size with durability %v: %d", d, obj.Size())
+		}
+		obj.Close()
+	}
+
+	//empty files only get a Close()
+	result, err := array.CreateFile("empty")
+	if err != nil {
+		t.Fatalf("failed to create files: %v", err)
+	}
+	result.SetDurability(DURABILITY_FSYNC_DIRS)
+	if err := result.Close(); err != nil {
+		t.Errorf("failed to close with directory syncs: %v", err)
+	}
+}
+
+func TestParseDurability(t *testing.T) {
+	for _, d := range []Durability{DURABILITY_NONE, DURABILITY_FSYNC, DURABILITY_FSYNC_DIRS} {
+		parsed, err := ParseDurability(d.String())
+		if err != nil || parsed != d {
+			t.Errorf("failed to round trip %v: %v %v", d, parsed, err)
+		}
+	}
+	if _, err := ParseDurability("yolo"); err == nil {
+		t.Errorf("expected an error for a bogus durability")
+	}
+}
@@ -0,0 +1,404 @@
+package raid5
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+//FS gives read only access to the array as an io/fs file system, for
+//http.FileServer, fs.WalkDir and friends.  object names with slashes
+//show up as directories, and only objects that ListFiles would return
+//are there at all.  files read the same way ReadFile does, so objects
+//missing a leg are reconstructed from parity.  it's also an
+//fs.ReadDirFS and an fs.StatFS.
+func (self *Array) FS() fs.FS {
+	return &arrayFS{array: self}
+}
+
+//the fs.FS from Array.FS
+type arrayFS struct {
+	array *Array
+}
+
+func (self *arrayFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	obj, entries, err := self.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return &dirFile{fs: self, name: name, entries: entries}, nil
+	}
+	return &objectFile{file: obj, name: name, sum: md5.New()}, nil
+}
+
+func (self *arrayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	entries, err := self.readDir("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		//there's either an object here or nothing at all
+		if _, err := self.stat("readdir", name); err != nil {
+			return nil, err
+		}
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return entries, nil
+}
+
+func (self *arrayFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	obj, _, err := self.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return dirInfo(name), nil
+	}
+	defer obj.Close()
+	return obj.info(path.Base(name)), nil
+}
+
+//whatever is at name, either an open object or the entries of a
+//directory.  the object is tried first, listing a directory means
+//going through everything in it so that's only done when there isn't
+//one.
+func (self *arrayFS) lookup(op string, name string) (*raid5File, []fs.DirEntry, error) {
+	var openErr error
+	if name != "." {
+		obj, err := self.array.OpenFile(name)
+		if err == nil {
+			return obj, nil, nil
+		}
+		openErr = pathError(op, name, err)
+	}
+	entries, err := self.readDir(op, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if entries == nil {
+		return nil, nil, openErr
+	}
+	return nil, entries, nil
+}
+
+//stat an object, which means opening it since its length and whether
+//it's there at all are up to the members to agree on
+func (self *arrayFS) stat(op string, name string) (fs.FileInfo, error) {
+	obj, err := self.array.OpenFile(name)
+	if err != nil {
+		return nil, pathError(op, name, err)
+	}
+	defer obj.Close()
+	return obj.info(path.Base(name)), nil
+}
+
+//the entries of the directory name, sorted, or nil if it isn't one.
+//directories only exist because there are objects in them, so an empty
+//one can't be told apart from one that isn't there.
+func (self *arrayFS) readDir(op string, name string) ([]fs.DirEntry, error) {
+	prefix := ""
+	if name != "." {
+		//things like the reserved directory aren't part of the array
+		if _, err := keyPath(name); err != nil {
+			return nil, nil
+		}
+		prefix = name + "/"
+	}
+	names, err := self.array.ListFiles(prefix)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if len(names) == 0 {
+		if name == "." {
+			return []fs.DirEntry{}, nil
+		}
+		return nil, nil
+	}
+
+	result := []fs.DirEntry{}
+	seen := make(map[string]bool)
+	for _, n := range names {
+		rest := strings.TrimPrefix(n, prefix)
+		if i := strings.Index(rest, "/"); i != -1 {
+			if !seen[rest[:i]] {
+				seen[rest[:i]] = true
+				result = append(result, &dirEntry{fs: self, name: rest[:i], dir: true})
+			}
+			continue
+		}
+		result = append(result, &dirEntry{fs: self, name: rest, object: n})
+	}
+	//ListFiles sorts whole names, "a/b" comes after "a.txt" there but
+	//the directory "a" comes before it here
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name() < result[j].Name()
+	})
+	return result, nil
+}
+
+//errors from the array are turned into something that errors.Is can
+//compare with fs.ErrNotExist, an object name the array would never
+//have made is just not there
+func pathError(op string, name string, err error) error {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) && os.IsNotExist(pathErr.Err) {
+		err = fs.ErrNotExist
+	} else if errors.Is(err, ErrInvalidName) {
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+//info about an open object, the length is the object's and not its
+//legs'.  the time is when the first leg we have was last written.
+func (self *raid5File) info(name string) fs.FileInfo {
+	result := &fileInfo{name: name, size: self.Size(), mode: 0444}
+	for _, f := range []*os.File{self.f1, self.f2, self.parity} {
+		if f == nil {
+			continue
+		}
+		if leg, err := f.Stat(); err == nil {
+			result.modTime = leg.ModTime()
+			break
+		}
+	}
+	return result
+}
+
+func dirInfo(name string) fs.FileInfo {
+	return &fileInfo{name: path.Base(name), mode: fs.ModeDir | 0555}
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (self *fileInfo) Name() string       { return self.name }
+func (self *fileInfo) Size() int64        { return self.size }
+func (self *fileInfo) Mode() fs.FileMode  { return self.mode }
+func (self *fileInfo) ModTime() time.Time { return self.modTime }
+func (self *fileInfo) IsDir() bool        { return self.mode.IsDir() }
+func (self *fileInfo) Sys() interface{}   { return nil }
+
+//an entry from ReadDir, the object isn't looked at until somebody
+//asks for Info()
+type dirEntry struct {
+	fs   *arrayFS
+	name string
+	dir  bool
+	//the full name of the object, "" for directories
+	object string
+}
+
+func (self *dirEntry) Name() string { return self.name }
+func (self *dirEntry) IsDir() bool  { return self.dir }
+
+func (self *dirEntry) Type() fs.FileMode {
+	if self.dir {
+		return fs.ModeDir
+	}
+	return 0
+}
+
+func (self *dirEntry) Info() (fs.FileInfo, error) {
+	if self.dir {
+		return dirInfo(self.name), nil
+	}
+	return self.fs.stat("stat", self.object)
+}
+
+func (self *dirEntry) String() string {
+	return fs.FormatDirEntry(self)
+}
+
+//an object opened through the FS.  reads from the start to the end
+//without any seeking in between are checked against the object's hash,
+//a mismatch is reported instead of io.EOF.
+type objectFile struct {
+	file   *raid5File
+	name   string
+	offset int64
+	//hash of everything read so far, nil once the reads have jumped
+	//around
+	sum hash.Hash
+	//the last stripe read, so small reads don't each read a whole
+	//stripe from the members
+	buf    []byte
+	bufOff int64
+	closed bool
+}
+
+func (self *objectFile) Stat() (fs.FileInfo, error) {
+	if self.closed {
+		return nil, &fs.PathError{Op: "stat", Path: self.name, Err: fs.ErrClosed}
+	}
+	return self.file.info(path.Base(self.name)), nil
+}
+
+func (self *objectFile) Read(p []byte) (int, error) {
+	if self.closed {
+		return 0, &fs.PathError{Op: "read", Path: self.name, Err: fs.ErrClosed}
+	}
+	if self.offset >= self.file.Size() {
+		if err := self.verify(); err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	var n int64
+	if self.offset >= self.bufOff && self.offset < self.bufOff+int64(len(self.buf)) {
+		n = int64(copy(p, self.buf[self.offset-self.bufOff:]))
+	} else if len(p) >= BLOCK_SIZE {
+		var err error
+		if n, err = self.file.ReadFile(p, self.offset); err != nil {
+			return 0, &fs.PathError{Op: "read", Path: self.name, Err: err}
+		}
+	} else {
+		if self.buf == nil {
+			self.buf = make([]byte, BLOCK_SIZE)
+		}
+		self.bufOff = self.offset - self.offset%BLOCK_SIZE
+		got, err := self.file.ReadFile(self.buf[:cap(self.buf)], self.bufOff)
+		if err != nil {
+			self.buf = self.buf[:0]
+			return 0, &fs.PathError{Op: "read", Path: self.name, Err: err}
+		}
+		self.buf = self.buf[:got]
+		n = int64(copy(p, self.buf[self.offset-self.bufOff:]))
+	}
+	if self.sum != nil {
+		self.sum.Write(p[:n])
+	}
+	self.offset += n
+	return int(n), nil
+}
+
+//called at the end of the object
+func (self *objectFile) verify() error {
+	if self.sum == nil || self.file.Hash() == nil {
+		return nil
+	}
+	sum := self.sum.Sum(nil)
+	//only once, reads past the end just get io.EOF
+	self.sum = nil
+	if !bytes.Equal(sum, self.file.Hash()) {
+		self.file.array.stats.checksumFailures.Add(1)
+		return &fs.PathError{Op: "read", Path: self.name, Err: ErrChecksum}
+	}
+	return nil
+}
+
+func (self *objectFile) ReadAt(p []byte, off int64) (int, error) {
+	if self.closed {
+		return 0, &fs.PathError{Op: "read", Path: self.name, Err: fs.ErrClosed}
+	}
+	n, err := self.file.ReadAt(p, off)
+	if err != nil && err != io.EOF {
+		err = &fs.PathError{Op: "read", Path: self.name, Err: err}
+	}
+	return n, err
+}
+
+func (self *objectFile) Seek(offset int64, whence int) (int64, error) {
+	if self.closed {
+		return 0, &fs.PathError{Op: "seek", Path: self.name, Err: fs.ErrClosed}
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += self.offset
+	case io.SeekEnd:
+		offset += self.file.Size()
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: self.name, Err: fmt.Errorf("bad whence %d", whence)}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: self.name, Err: fs.ErrInvalid}
+	}
+	if offset != self.offset {
+		self.sum = nil
+	}
+	self.offset = offset
+	return offset, nil
+}
+
+func (self *objectFile) Close() error {
+	if self.closed {
+		return &fs.PathError{Op: "close", Path: self.name, Err: fs.ErrClosed}
+	}
+	self.closed = true
+	return self.file.Close()
+}
+
+//a directory opened through the FS, the entries are read when it's
+//opened
+type dirFile struct {
+	fs      *arrayFS
+	name    string
+	entries []fs.DirEntry
+	closed  bool
+}
+
+func (self *dirFile) Stat() (fs.FileInfo, error) {
+	if self.closed {
+		return nil, &fs.PathError{Op: "stat", Path: self.name, Err: fs.ErrClosed}
+	}
+	return dirInfo(self.name), nil
+}
+
+func (self *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: self.name, Err: errors.New("is a directory")}
+}
+
+func (self *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if self.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: self.name, Err: fs.ErrClosed}
+	}
+	if n <= 0 {
+		result := self.entries
+		self.entries = nil
+		return result, nil
+	}
+	if len(self.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(self.entries) {
+		n = len(self.entries)
+	}
+	result := self.entries[:n:n]
+	self.entries = self.entries[n:]
+	return result, nil
+}
+
+func (self *dirFile) Close() error {
+	if self.closed {
+		return &fs.PathError{Op: "close", Path: self.name, Err: fs.ErrClosed}
+	}
+	self.closed = true
+	return nil
+}
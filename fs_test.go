@@ -0,0 +1,110 @@
+package raid5
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	content := bytes.Repeat([]byte("in a file system "), (HALF_BLOCK+300)/17)
+	writeTestObject(t, array, "top", content[:100])
+	writeTestObject(t, array, "a.txt", content[:1000])
+	writeTestObject(t, array, "a/b/c", content)
+	writeTestObject(t, array, "a/empty", nil)
+	//reads of this one have to be reconstructed
+	if err := os.Remove(filepath.Join(d1, "a", "b", "c")); err != nil {
+		t.Fatalf("failed to remove link: %v", err)
+	}
+
+	fsys := array.FS()
+	if err := fstest.TestFS(fsys, "top", "a.txt", "a/b/c", "a/empty"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := fs.ReadDir(fsys, "a/b")
+	if err != nil {
+		t.Fatalf("failed to read directory: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "c" {
+		t.Errorf("expected just c in a/b, got %v", entries)
+	}
+	info, err := fs.Stat(fsys, "a/b/c")
+	if err != nil {
+		t.Fatalf("failed to stat: %v", err)
+	}
+	if info.Size() != int64(len(content)) {
+		t.Errorf("expected the object's size %d, got %d", len(content), info.Size())
+	}
+	got, err := fs.ReadFile(fsys, "a/b/c")
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("wrong content read back: %v", err)
+	}
+
+	for _, name := range []string{"nope", "a/b/nope", RESERVED_DIR, "top.lock"} {
+		if _, err := fsys.Open(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected %s not to exist, got %v", name, err)
+		}
+	}
+	if _, ok := fsys.(fs.ReadDirFS); !ok {
+		t.Errorf("expected a ReadDirFS")
+	}
+	if _, ok := fsys.(fs.StatFS); !ok {
+		t.Errorf("expected a StatFS")
+	}
+	//trying a directory as an object first isn't a member's fault
+	for i, m := range array.Members() {
+		if m.Errors != 0 {
+			t.Errorf("member %d has errors: %+v", i, m)
+		}
+	}
+}
+
+func TestReadFromOffset(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	content := make([]byte, 2*BLOCK_SIZE+1234)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	writeTestObject(t, array, "offsets", content)
+
+	obj, err := array.OpenFile("offsets")
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer obj.Close()
+	for _, off := range []int64{0, 1, HALF_BLOCK - 1, HALF_BLOCK, BLOCK_SIZE, BLOCK_SIZE + HALF_BLOCK + 7, int64(len(content)) - 3} {
+		for _, size := range []int{1, 100, BLOCK_SIZE, 3 * BLOCK_SIZE} {
+			out := make([]byte, size)
+			n, err := obj.ReadAt(out, off)
+			want := content[off:]
+			if len(want) > size {
+				want = want[:size]
+			}
+			if n != len(want) || !bytes.Equal(out[:n], want) {
+				t.Errorf("wrong content reading %d at %d: got %d bytes", size, off, n)
+			}
+			if n < size && err != io.EOF {
+				t.Errorf("expected EOF for a short read of %d at %d, got %v", size, off, err)
+			}
+			if n == size && err != nil {
+				t.Errorf("failed to read %d at %d: %v", size, off, err)
+			}
+		}
+	}
+	if n, err := obj.ReadAt(make([]byte, 10), int64(len(content))); n != 0 || err != io.EOF {
+		t.Errorf("expected EOF at the end, got %d %v", n, err)
+	}
+}
@@ -0,0 +1,404 @@
+package raid5
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//FsckKind is the sort of thing Fsck found wrong.
+type FsckKind int
+
+const (
+	//a leg ($len$hash file) with no link pointing at it, left by a crash
+	//between the rename and the symlink in WriteAndClose
+	FSCK_ORPHAN FsckKind = iota
+	//a link whose leg isn't there
+	FSCK_DANGLING
+	//data still sitting under the plain name, from a crash before
+	//WriteAndClose got to the rename
+	FSCK_STAGING
+	//a file in a member that the library would never have made
+	FSCK_UNKNOWN
+	//only one member has the object, which isn't enough to read it
+	FSCK_LONELY
+	//the members' links point at legs with different lengths or hashes
+	FSCK_DISAGREE
+	//one member is missing its leg of an object the other two have
+	FSCK_MISSING_LEG
+)
+
+var fsckKindNames = []string{"orphan", "dangling link", "staging file", "unknown file",
+	"only in one member", "links disagree", "missing leg"}
+
+func (self FsckKind) String() string {
+	if int(self) < len(fsckKindNames) {
+		return fsckKindNames[self]
+	}
+	return fmt.Sprintf("FsckKind(%d)", int(self))
+}
+
+//FsckProblem is one thing Fsck found.
+type FsckProblem struct {
+	Kind FsckKind
+	//the object it belongs to, "" if there's no telling
+	Name string
+	//-1 when it's about the object as a whole
+	Member int
+	//the file in the member, if there is one
+	Path string
+	//what was done about it, "" for nothing
+	Fix string
+}
+
+func (self FsckProblem) String() string {
+	result := self.Kind.String()
+	if self.Name != "" {
+		result = self.Name + ": " + result
+	}
+	if self.Member >= 0 {
+		result += " on member " + memberNames[self.Member]
+	}
+	if self.Path != "" {
+		result += " (" + self.Path + ")"
+	}
+	if self.Fix != "" {
+		result += ", " + self.Fix
+	}
+	return result
+}
+
+//FsckReport is what Fsck found.
+type FsckReport struct {
+	//how many object names were looked at
+	Objects  int
+	Problems []FsckProblem
+	//where things were moved to in each member, "" if nothing was
+	LostAndFound string
+}
+
+//Unfixed counts the problems that were left alone.
+func (self *FsckReport) Unfixed() int {
+	ct := 0
+	for _, p := range self.Problems {
+		if p.Fix == "" {
+			ct++
+		}
+	}
+	return ct
+}
+
+//where Fsck moves what it takes out of the array, in each member
+const LOST_AND_FOUND = "lost+found"
+
+//Fsck looks at every file in the members for the debris that crashes
+//leave behind, and at every object for members that disagree about it.
+//with repair set the debris is moved into .raid5/lost+found/<time> in
+//the member it was found in (or just removed, for links), stale legs
+//are moved out of the way and missing legs are rebuilt.  objects are
+//locked while they are looked at, but it's meant to be run when not
+//much else is going on, like at startup.
+func (self *Array) Fsck(repair bool) (*FsckReport, error) {
+	check := &fsckRun{
+		array:   self,
+		repair:  repair,
+		report:  &FsckReport{},
+		stamp:   time.Now().Format("20060102-150405"),
+		targets: make(map[string][][]string),
+	}
+	dirs := self.Dirs()
+	for i, dir := range dirs {
+		if !self.writable(i) {
+			continue
+		}
+		if err := check.walk(i, dir); err != nil {
+			return nil, self.memberErrorIn(dir, i, "fsck", err)
+		}
+	}
+	names := []string{}
+	for name := range check.targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	check.report.Objects = len(names)
+	for _, name := range names {
+		if err := check.object(name); err != nil {
+			return nil, err
+		}
+	}
+	return check.report, nil
+}
+
+//one Fsck in progress
+type fsckRun struct {
+	array  *Array
+	repair bool
+	report *FsckReport
+	//name of this run's directory in lost+found
+	stamp string
+	//every object name seen, with the legs ($len$hash files) found for
+	//it in each member, as paths relative to the member
+	targets map[string][][]string
+}
+
+func (self *fsckRun) found(kind FsckKind, name string, member int, path string) *FsckProblem {
+	self.report.Problems = append(self.report.Problems, FsckProblem{Kind: kind, Name: name, Member: member, Path: path})
+	return &self.report.Problems[len(self.report.Problems)-1]
+}
+
+//collect the names and legs in one member
+func (self *fsckRun) walk(member int, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path == filepath.Join(dir, RESERVED_DIR) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		for _, suffix := range reservedSuffixes {
+			if strings.HasSuffix(info.Name(), suffix) {
+				return nil
+			}
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		var leg string
+		if strings.Index(info.Name(), "$") != -1 {
+			piece, _, _, err := decodeMetadata(info.Name())
+			if err == nil {
+				leg = rel
+				name = filepath.ToSlash(filepath.Join(filepath.Dir(rel), piece))
+			}
+		}
+		if _, err := keyPath(name); err != nil {
+			problem := self.found(FSCK_UNKNOWN, "", member, path)
+			if self.repair {
+				problem.Fix = self.quarantine(member, dir, rel)
+			}
+			return nil
+		}
+		if _, ok := self.targets[name]; !ok {
+			self.targets[name] = make([][]string, len(memberNames))
+		}
+		if leg != "" {
+			self.targets[name][member] = append(self.targets[name][member], leg)
+		}
+		return nil
+	})
+}
+
+//what one member has under an object's plain name
+type fsckLeg struct {
+	//the leg the link points at relative to the member, "" for a zero
+	//length object or when there's nothing usable
+	final string
+	ok    bool
+}
+
+//look over one object in every member that can have it, with the object
+//locked.  missing legs are rebuilt afterwards, since that takes the
+//lock itself.
+func (self *fsckRun) object(name string) error {
+	path, _ := keyPath(name)
+	dirs := self.array.Dirs()
+	lock, err := Locks.lock(self.array.lockKey, dirs, path, true)
+	if err != nil {
+		return err
+	}
+	rebuild := self.examine(name, path, dirs)
+	lock.Unlock()
+
+	if !self.repair {
+		return nil
+	}
+	for _, r := range rebuild {
+		problem := &self.report.Problems[r.problem]
+		if err := self.array.rebuildObject(r.member, dirs[r.member], name, false); err != nil {
+			log.Printf("fsck: unable to rebuild %s on member %s: %v", name, memberNames[r.member], err)
+			continue
+		}
+		if problem.Fix == "" {
+			problem.Fix = "rebuilt"
+		} else {
+			problem.Fix += ", rebuilt"
+		}
+	}
+	return nil
+}
+
+//a leg that has to be rebuilt, and the problem that says so
+type fsckRebuild struct {
+	member  int
+	problem int
+}
+
+//called with the object locked, returns the legs that need to be
+//rebuilt
+func (self *fsckRun) examine(name string, path string, dirs []string) []fsckRebuild {
+	legs := make([]fsckLeg, len(dirs))
+	var members []int
+	for i, dir := range dirs {
+		if !self.array.usable(i, name) {
+			continue
+		}
+		members = append(members, i)
+		link := filepath.Join(dir, path)
+		info, err := os.Lstat(link)
+		switch {
+		case err != nil || info.IsDir():
+			//nothing here
+		case info.Mode()&os.ModeSymlink != 0:
+			dest, err := os.Readlink(link)
+			if err != nil {
+				break
+			}
+			final := filepath.Join(filepath.Dir(path), filepath.Base(dest))
+			if _, err := os.Stat(filepath.Join(dir, final)); err != nil {
+				problem := self.found(FSCK_DANGLING, name, i, link)
+				if self.repair && os.Remove(link) == nil {
+					problem.Fix = "removed"
+				}
+				break
+			}
+			legs[i] = fsckLeg{final: final, ok: true}
+		case info.Size() != 0:
+			problem := self.found(FSCK_STAGING, name, i, link)
+			if self.repair {
+				problem.Fix = self.quarantine(i, dir, path)
+			}
+		default:
+			legs[i] = fsckLeg{ok: true}
+		}
+	}
+
+	//the members that have it vote on what it is
+	votes := make(map[string]int)
+	for _, leg := range legs {
+		if leg.ok {
+			votes[filepath.Base(leg.final)]++
+		}
+	}
+	winner, have := "", 0
+	for final, ct := range votes {
+		have += ct
+		if ct >= 2 {
+			winner = final
+		}
+	}
+
+	var rebuild []fsckRebuild
+	switch {
+	case have == 0:
+		//just debris
+	case winner == "" && have == 1:
+		if len(members) < 2 {
+			break //with the others gone, there's nothing to compare with
+		}
+		for _, i := range members {
+			if legs[i].ok {
+				problem := self.found(FSCK_LONELY, name, i, filepath.Join(dirs[i], path))
+				if self.repair {
+					problem.Fix = self.removeObject(i, dirs[i], path, legs[i].final)
+					legs[i] = fsckLeg{}
+				}
+			}
+		}
+	case winner == "" && have > 1:
+		//two members that disagree, no way to tell which one is right
+		self.found(FSCK_DISAGREE, name, -1, "")
+	default:
+		for _, i := range members {
+			if legs[i].ok && filepath.Base(legs[i].final) == winner {
+				continue
+			}
+			if !legs[i].ok {
+				problem := self.found(FSCK_MISSING_LEG, name, i, filepath.Join(dirs[i], path))
+				//the leg might be there with just the link missing
+				final := filepath.Join(filepath.Dir(path), winner)
+				if self.repair && self.hasTarget(name, i, final) {
+					if err := os.Symlink(filepath.Join(dirs[i], final), filepath.Join(dirs[i], path)); err == nil {
+						problem.Fix = "relinked"
+						legs[i] = fsckLeg{final: final, ok: true}
+						continue
+					}
+				}
+			} else {
+				problem := self.found(FSCK_DISAGREE, name, i, filepath.Join(dirs[i], path))
+				if !self.repair {
+					continue
+				}
+				if problem.Fix = self.removeObject(i, dirs[i], path, legs[i].final); problem.Fix == "" {
+					continue
+				}
+				legs[i] = fsckLeg{}
+			}
+			rebuild = append(rebuild, fsckRebuild{member: i, problem: len(self.report.Problems) - 1})
+		}
+	}
+
+	//legs that nothing points at any more
+	for _, i := range members {
+		for _, final := range self.targets[name][i] {
+			if legs[i].ok && final == legs[i].final {
+				continue
+			}
+			if _, err := os.Lstat(filepath.Join(dirs[i], final)); err != nil {
+				continue //moved out of the way above
+			}
+			problem := self.found(FSCK_ORPHAN, name, i, filepath.Join(dirs[i], final))
+			if self.repair {
+				problem.Fix = self.quarantine(i, dirs[i], final)
+			}
+		}
+	}
+	return rebuild
+}
+
+//did the walk find the leg final for the object in member?
+func (self *fsckRun) hasTarget(name string, member int, final string) bool {
+	for _, found := range self.targets[name][member] {
+		if found == final {
+			return true
+		}
+	}
+	return false
+}
+
+//take an object out of a member, the link is removed and the leg moved
+//to lost+found
+func (self *fsckRun) removeObject(member int, dir string, path string, final string) string {
+	if final == "" {
+		return self.quarantine(member, dir, path)
+	}
+	if err := os.Remove(filepath.Join(dir, path)); err != nil {
+		log.Printf("fsck: unable to remove %s: %v", filepath.Join(dir, path), err)
+		return ""
+	}
+	return self.quarantine(member, dir, final)
+}
+
+//move the file at rel in a member to its lost+found, and say so
+func (self *fsckRun) quarantine(member int, dir string, rel string) string {
+	lost := filepath.Join(dir, RESERVED_DIR, LOST_AND_FOUND, self.stamp)
+	dest := filepath.Join(lost, rel)
+	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	if err == nil {
+		err = os.Rename(filepath.Join(dir, rel), dest)
+	}
+	if err != nil {
+		log.Printf("fsck: unable to move %s to %s: %v", filepath.Join(dir, rel), lost, err)
+		return ""
+	}
+	self.report.LostAndFound = filepath.Join(RESERVED_DIR, LOST_AND_FOUND, self.stamp)
+	return "moved to " + dest
+}
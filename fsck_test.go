@@ -0,0 +1,110 @@
+package raid5
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFsck(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	content := bytes.Repeat([]byte("what a mess "), BLOCK_SIZE/7)
+	for _, name := range []string{"fine", "unlinked", "dangling", "lonely", "stale", "dir/nested"} {
+		writeTestObject(t, array, name, content)
+	}
+	writeTestObject(t, array, "empty", nil)
+	final := func(dir, name string) string {
+		dest, err := os.Readlink(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("no link for %s: %v", name, err)
+		}
+		return dest
+	}
+	remove := func(path string) {
+		if err := os.Remove(path); err != nil {
+			t.Fatalf("failed to remove: %v", err)
+		}
+	}
+	//crashed between the rename and the symlink
+	remove(filepath.Join(d1, "unlinked"))
+	//lost its leg
+	remove(final(d2, "dangling"))
+	//crashed before the rename, on the only member it got to
+	if err := ioutil.WriteFile(filepath.Join(parity, "staged"), content, 0644); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	//only d1 has it
+	for _, dir := range []string{d2, parity} {
+		remove(final(dir, "lonely"))
+		remove(filepath.Join(dir, "lonely"))
+	}
+	//parity has an older version
+	old := filepath.Join(parity, "stale$12$00000000000000000000000000000000")
+	if err := ioutil.WriteFile(old, make([]byte, HALF_BLOCK), 0644); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	remove(filepath.Join(parity, "stale"))
+	if err := os.Symlink(old, filepath.Join(parity, "stale")); err != nil {
+		t.Fatalf("failed to link: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(d2, "junk$"), nil, 0644); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	expected := map[FsckKind]int{FSCK_ORPHAN: 2, FSCK_MISSING_LEG: 2, FSCK_DANGLING: 1,
+		FSCK_STAGING: 1, FSCK_LONELY: 1, FSCK_DISAGREE: 1, FSCK_UNKNOWN: 1}
+	count := func(report *FsckReport) map[FsckKind]int {
+		result := make(map[FsckKind]int)
+		for _, p := range report.Problems {
+			result[p.Kind]++
+		}
+		return result
+	}
+	report, err := array.Fsck(false)
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	}
+	for kind, ct := range expected {
+		if count(report)[kind] != ct {
+			t.Errorf("expected %d %s, got %v", ct, kind, report.Problems)
+		}
+	}
+	if report.Unfixed() != len(report.Problems) {
+		t.Errorf("fsck without repair fixed something: %v", report.Problems)
+	}
+
+	report, err = array.Fsck(true)
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	}
+	//the leg that lost its link just gets it back, so it's not an orphan
+	//any more
+	if report.Unfixed() != 0 || len(report.Problems) != 8 {
+		t.Errorf("expected everything to be fixed: %v", report.Problems)
+	}
+	for _, p := range report.Problems {
+		if p.Name == "unlinked" && p.Fix != "relinked" {
+			t.Errorf("expected unlinked to be relinked: %v", p)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(parity, report.LostAndFound, "staged")); err != nil {
+		t.Errorf("staging file wasn't kept: %v", err)
+	}
+	report, err = array.Fsck(false)
+	if err != nil || len(report.Problems) != 0 {
+		t.Fatalf("still problems after repair: %v %v", report.Problems, err)
+	}
+	for _, name := range []string{"fine", "unlinked", "dangling", "stale", "dir/nested"} {
+		if err := array.Verify(name); err != nil {
+			t.Errorf("%s is still broken: %v", name, err)
+		}
+	}
+	if _, err := array.OpenFile("lonely"); !os.IsNotExist(err) {
+		t.Errorf("expected lonely to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+package raid5
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+//MemberState is where a member is in its life.  members start out
+//ONLINE, pick up I/O errors and become DEGRADED, and after MaxErrors of
+//them are FAILED, at which point nothing reads or writes them until
+//somebody rebuilds or replaces them.  a REBUILDING member has only been
+//refilled up to some object, see usable().
+type MemberState int
+
+const (
+	MEMBER_ONLINE MemberState = iota
+	MEMBER_DEGRADED
+	MEMBER_FAILED
+	MEMBER_REBUILDING
+)
+
+var memberStateNames = []string{"online", "degraded", "failed", "rebuilding"}
+
+func (self MemberState) String() string {
+	if self < 0 || int(self) >= len(memberStateNames) {
+		return fmt.Sprintf("MemberState(%d)", int(self))
+	}
+	return memberStateNames[self]
+}
+
+//ParseMemberState is the opposite of String()
+func ParseMemberState(s string) (MemberState, error) {
+	for i, name := range memberStateNames {
+		if strings.EqualFold(s, name) {
+			return MemberState(i), nil
+		}
+	}
+	return MEMBER_ONLINE, fmt.Errorf("unknown member state %q, expected one of %s",
+		s, strings.Join(memberStateNames, ", "))
+}
+
+//default for Array.MaxErrors
+const MAX_MEMBER_ERRORS = 3
+
+//what we know about one member
+type memberHealth struct {
+	state  MemberState
+	errors int
+}
+
+//MemberStatus is a snapshot of one member for people outside the
+//library, the index in the slice from Members() is the member number.
+type MemberStatus struct {
+	Dir    string
+	State  MemberState
+	Errors int
+	//bytes free and total on the member's disk, zero if they couldn't
+	//be found out
+	Free, Size uint64
+}
+
+//Members reports the current state of every member.
+func (self *Array) Members() []MemberStatus {
+	self.mu.Lock()
+	result := make([]MemberStatus, len(self.dirs))
+	for i, dir := range self.dirs {
+		result[i] = MemberStatus{
+			Dir:    dir,
+			State:  self.members[i].state,
+			Errors: self.members[i].errors,
+		}
+	}
+	self.mu.Unlock()
+	//no need to hold up everybody else while the disks answer
+	for i := range result {
+		if result[i].State != MEMBER_FAILED {
+			result[i].Free, result[i].Size, _ = diskSpace(result[i].Dir)
+		}
+	}
+	return result
+}
+
+//RebuildCursor is the name of the last object copied to a rebuilding
+//member, everything up to and including it is safe to read there.
+func (self *Array) RebuildCursor() string {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.rebuildCursor
+}
+
+//SetMemberState moves a member to a new state by hand.  going back to
+//ONLINE forgets about the member's old errors.  the change is written
+//to the superblocks of arrays from OpenArray.
+func (self *Array) SetMemberState(member int, state MemberState) error {
+	if member < 0 || member >= len(self.dirs) {
+		return fmt.Errorf("no member %d in the array", member)
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if state == MEMBER_ONLINE {
+		self.members[member].errors = 0
+	}
+	if state == MEMBER_REBUILDING {
+		self.rebuildCursor = ""
+	}
+	return self.setState(member, state, "requested")
+}
+
+//called with the lock held
+func (self *Array) setState(member int, state MemberState, why string) error {
+	old := self.members[member].state
+	if old == state {
+		return nil
+	}
+	self.members[member].state = state
+	self.logState(member, old, why)
+	err := self.saveSuperblocks()
+	if state == MEMBER_FAILED {
+		self.promoteSpare(member)
+	}
+	return err
+}
+
+func (self *Array) logState(member int, old MemberState, why string) {
+	log.Printf("member %s (%s) is now %s, was %s: %s", memberNames[member],
+		self.dirs[member], self.members[member].state, old, why)
+}
+
+//everything that goes wrong with a member comes through here, so it can
+//be counted against the member.  returns the error to give back to
+//the caller.
+func (self *Array) memberError(member int, op string, err error) error {
+	return self.memberErrorIn("", member, op, err)
+}
+
+//memberError for somebody working from their own copy of the member
+//directories.  if the member has been swapped for a spare since then,
+//the error belongs to the old directory and isn't held against the new
+//one.
+func (self *Array) memberErrorIn(dir string, member int, op string, err error) error {
+	result := &MemberError{Member: member, Op: op, Err: err}
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if dir != "" && dir != self.dirs[member] {
+		return result
+	}
+	self.stats.memberErrors[member].Add(1)
+	h := &self.members[member]
+	h.errors++
+	switch {
+	case h.state == MEMBER_FAILED:
+		//nothing more can happen to it
+	case h.errors >= self.MaxErrors:
+		self.setState(member, MEMBER_FAILED, fmt.Sprintf("%d errors, last was %v", h.errors, result))
+	case h.state == MEMBER_ONLINE:
+		self.setState(member, MEMBER_DEGRADED, result.Error())
+	}
+	return result
+}
+
+//can we use this member for the named object?  failed members are
+//never used.  a rebuilding member is only good for the objects the
+//rebuild has already gotten to, which it does in sorted order.
+func (self *Array) usable(member int, name string) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	switch self.members[member].state {
+	case MEMBER_FAILED:
+		return false
+	case MEMBER_REBUILDING:
+		return self.rebuildCursor != "" && name <= self.rebuildCursor
+	}
+	return true
+}
+
+//where to read member's leg of the named object from, "" if there's
+//nowhere.  it's the member's directory if usable(), or the directory
+//the member is replacing if that's still being copied from.
+func (self *Array) memberDir(member int, name string) string {
+	if self.usable(member, name) {
+		return self.Dirs()[member]
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.members[member].state == MEMBER_REBUILDING {
+		return self.retiring
+	}
+	return ""
+}
+
+//writes go to rebuilding members (they need the new objects too) but
+//not to failed ones
+func (self *Array) writable(member int) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.members[member].state != MEMBER_FAILED
+}
+
+//errors from the files of an object are counted against the member
+//directory the object was opened in
+func (self *raid5File) memberError(member int, op string, err error) error {
+	return self.array.memberErrorIn(self.dirs[member], member, op, err)
+}
@@ -0,0 +1,290 @@
+package raid5
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestObject(t *testing.T, array *Array, name string, content []byte) {
+	result, err := array.CreateFile(name)
+	if err != nil {
+		t.Fatalf("failed to create %s: %v", name, err)
+	}
+	if _, _, err := result.WriteAndClose(content); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+}
+
+func readTestObject(t *testing.T, array *Array, name string) []byte {
+	obj, err := array.OpenFile(name)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", name, err)
+	}
+	defer obj.Close()
+	out := make([]byte, obj.Size())
+	if _, err := obj.ReadFile(out, 0); err != nil {
+		t.Fatalf("failed to read %s: %v", name, err)
+	}
+	return out
+}
+
+func TestSuperblocksRemember(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array, err := OpenArray(d1, d2, parity)
+	if err != nil {
+		t.Fatalf("failed to open new array: %v", err)
+	}
+	if array.ID() == "" {
+		t.Fatalf("new array has no id")
+	}
+	if err := array.SetMemberState(MEMBER_D2, MEMBER_FAILED); err != nil {
+		t.Fatalf("failed to set state: %v", err)
+	}
+
+	again, err := OpenArray(d1, d2, parity)
+	if err != nil {
+		t.Fatalf("failed to reopen array: %v", err)
+	}
+	if again.ID() != array.ID() {
+		t.Errorf("reopened array has a different id")
+	}
+	if state := again.Members()[MEMBER_D2].State; state != MEMBER_FAILED {
+		t.Errorf("failed state was forgotten, member is %v", state)
+	}
+
+	//members in the wrong order is a config mistake, not a failure
+	if _, err := OpenArray(d2, d1, parity); err == nil {
+		t.Errorf("expected an error opening members out of order")
+	}
+}
+
+func TestMissingSuperblockFailsMember(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	if _, err := OpenArray(d1, d2, parity); err != nil {
+		t.Fatalf("failed to open new array: %v", err)
+	}
+	//as if the parity disk didn't get mounted
+	if err := os.RemoveAll(filepath.Join(parity, RESERVED_DIR)); err != nil {
+		t.Fatalf("failed to remove superblock: %v", err)
+	}
+	array, err := OpenArray(d1, d2, parity)
+	if err != nil {
+		t.Fatalf("failed to reopen array: %v", err)
+	}
+	if state := array.Members()[MEMBER_PARITY].State; state != MEMBER_FAILED {
+		t.Errorf("member without a superblock should be failed, is %v", state)
+	}
+}
+
+func TestMangledSuperblock(t *testing.T) {
+	for _, mangle := range []func(*superblock){
+		func(sb *superblock) { sb.Member = 7 },
+		func(sb *superblock) { sb.Member = -1 },
+		func(sb *superblock) { sb.States = append(sb.States, MEMBER_ONLINE) },
+		func(sb *superblock) { sb.States = sb.States[:1] },
+	} {
+		d1, d2, parity := setupTestDirs(t)
+		if _, err := OpenArray(d1, d2, parity); err != nil {
+			t.Fatalf("failed to open new array: %v", err)
+		}
+		sb, err := readSuperblock(d1)
+		if err != nil {
+			t.Fatalf("failed to read superblock: %v", err)
+		}
+		mangle(sb)
+		raw, _ := json.Marshal(sb)
+		if err := ioutil.WriteFile(superblockPath(d1), raw, 0644); err != nil {
+			t.Fatalf("failed to write superblock: %v", err)
+		}
+		if _, err := readSuperblock(d1); err == nil {
+			t.Errorf("expected the superblock %+v to be refused", sb)
+		}
+		//the others still know what the array is
+		array, err := OpenArray(d1, d2, parity)
+		if err != nil {
+			t.Fatalf("failed to reopen array: %v", err)
+		}
+		if state := array.Members()[MEMBER_D1].State; state != MEMBER_FAILED {
+			t.Errorf("member with a bad superblock should be failed, is %v", state)
+		}
+		array.Close()
+		destroyTestDirs(t, d1, d2, parity)
+	}
+}
+
+func TestErrorsFailMember(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array, err := OpenArray(d1, d2, parity)
+	if err != nil {
+		t.Fatalf("failed to open new array: %v", err)
+	}
+	boom := errors.New("boom")
+	array.memberError(MEMBER_D1, "read", boom)
+	if state := array.Members()[MEMBER_D1].State; state != MEMBER_DEGRADED {
+		t.Errorf("one error should make a member degraded, is %v", state)
+	}
+	for i := 1; i < array.MaxErrors; i++ {
+		array.memberError(MEMBER_D1, "read", boom)
+	}
+	if state := array.Members()[MEMBER_D1].State; state != MEMBER_FAILED {
+		t.Errorf("%d errors should fail a member, is %v", array.MaxErrors, state)
+	}
+
+	//the failed member is left alone for writes and reads
+	content := bytes.Repeat([]byte("skip d1 "), BLOCK_SIZE/4)
+	writeTestObject(t, array, "dodge", content)
+	if _, err := os.Lstat(filepath.Join(d1, "dodge")); !os.IsNotExist(err) {
+		t.Errorf("failed member should not have been written, got %v", err)
+	}
+	if !bytes.Equal(readTestObject(t, array, "dodge"), content) {
+		t.Errorf("wrong content read back without d1")
+	}
+	names, err := array.ListFiles("")
+	if err != nil || len(names) != 1 || names[0] != "dodge" {
+		t.Errorf("expected to list the object without d1: %v %v", names, err)
+	}
+
+	//a second failure is one too many
+	array.SetMemberState(MEMBER_PARITY, MEMBER_FAILED)
+	if _, err := array.CreateFile("nope"); !errors.Is(err, ErrArrayFailed) {
+		t.Errorf("expected array failed error, got %v", err)
+	}
+}
+
+func TestRebuildingMemberOnlyUpToCursor(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	writeTestObject(t, array, "apple", []byte("apple"))
+	writeTestObject(t, array, "zebra", []byte("zebra"))
+	//break both objects on d1, so that reading them through d1 fails
+	for _, name := range []string{"apple", "zebra"} {
+		obj, err := array.OpenFile(name)
+		if err != nil {
+			t.Fatalf("failed to open: %v", err)
+		}
+		obj.Close()
+		if err := os.Truncate(filepath.Join(d1, obj.finalName), 0); err != nil {
+			t.Fatalf("failed to truncate: %v", err)
+		}
+	}
+
+	array.SetMemberState(MEMBER_D1, MEMBER_REBUILDING)
+	array.mu.Lock()
+	array.rebuildCursor = "apple"
+	array.mu.Unlock()
+
+	//zebra isn't rebuilt so d1 is ignored and parity covers for it
+	if string(readTestObject(t, array, "zebra")) != "zebra" {
+		t.Errorf("object past the rebuild cursor should come from parity")
+	}
+	//apple is supposedly rebuilt, so d1 gets used (and is wrong)
+	obj, err := array.OpenFile("apple")
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer obj.Close()
+	if obj.f1 == nil {
+		t.Errorf("object before the rebuild cursor should use the rebuilding member")
+	}
+}
+
+func TestReservedDirIsNotAName(t *testing.T) {
+	for _, name := range []string{RESERVED_DIR, RESERVED_DIR + "/superblock"} {
+		if err := ValidateName(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("expected %s to be reserved, got %v", name, err)
+		}
+	}
+}
+
+func TestCheckArray(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	if err := CheckArray(d1, d2, parity); err != nil {
+		t.Errorf("new array should be fine: %v", err)
+	}
+	array, err := OpenArray(d1, d2, parity)
+	if err != nil {
+		t.Fatalf("failed to open new array: %v", err)
+	}
+	if err := CheckArray(d1, d2, parity); err != nil {
+		t.Errorf("set up array should be fine: %v", err)
+	}
+
+	os.RemoveAll(filepath.Join(d2, RESERVED_DIR))
+	if err := CheckArray(d1, d2, parity); !errors.Is(err, ErrHalfInitialized) {
+		t.Errorf("expected half initialized error, got %v", err)
+	}
+	//once the array knows about it, it's just a failed member
+	array.SetMemberState(MEMBER_D2, MEMBER_FAILED)
+	if err := CheckArray(d1, d2, parity); err != nil {
+		t.Errorf("failed member without a superblock should be fine: %v", err)
+	}
+
+	if err := CheckArray(d1, d2, filepath.Join(parity, "nope")); err == nil {
+		t.Errorf("expected an error for a missing directory")
+	}
+}
+
+func TestReadsSurviveMemberErrors(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	content := bytes.Repeat([]byte("bad sectors "), 3*BLOCK_SIZE/4)
+	writeTestObject(t, array, "unopenable", content)
+	writeTestObject(t, array, "unreadable", content)
+
+	//a link that goes round in circles can't be opened, and isn't just
+	//missing either
+	link := filepath.Join(d1, "unopenable")
+	os.Remove(link)
+	if err := os.Symlink(link, link); err != nil {
+		t.Fatalf("failed to make a loop: %v", err)
+	}
+	if !bytes.Equal(readTestObject(t, array, "unopenable"), content) {
+		t.Errorf("wrong content read back without d1")
+	}
+	if m := array.Members()[MEMBER_D1]; m.State != MEMBER_DEGRADED || m.Errors != 1 {
+		t.Errorf("expected the open error to count against d1: %+v", m)
+	}
+
+	//a directory can be opened but not read
+	dest, err := os.Readlink(filepath.Join(d2, "unreadable"))
+	if err != nil {
+		t.Fatalf("no link: %v", err)
+	}
+	os.Remove(dest)
+	if err := os.Mkdir(dest, 0755); err != nil {
+		t.Fatalf("failed to replace leg: %v", err)
+	}
+	before := array.Stats().DegradedReads
+	if !bytes.Equal(readTestObject(t, array, "unreadable"), content) {
+		t.Errorf("wrong content read back without d2")
+	}
+	if m := array.Members()[MEMBER_D2]; m.State != MEMBER_DEGRADED || m.Errors == 0 {
+		t.Errorf("expected the read error to count against d2: %+v", m)
+	}
+	if array.Stats().DegradedReads != before+1 {
+		t.Errorf("switching to parity should be a degraded read")
+	}
+
+	//two is too many
+	os.Remove(filepath.Join(d2, "unopenable"))
+	if _, err := array.OpenFile("unopenable"); !errors.Is(err, ErrArrayFailed) {
+		t.Errorf("expected the array to have failed for the object, got %v", err)
+	}
+}
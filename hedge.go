@@ -0,0 +1,104 @@
+package raid5
+
+import (
+	"context"
+	"time"
+
+	"github.com/iansmith/raid5/parity"
+)
+
+//wait for both legs of a stripe from r1 and r2.  when hedging, a data
+//leg that is still going HedgeAfter after the stripe was asked for is
+//worked out from the other one and parity instead, the slow read is
+//left to finish on its own, and s.hedged says which member it was.
+//returns the member a read failed on, and
+//how, or -1 and ctx.Err() if ctx was done first.
+func (self *raid5File) awaitStripe(ctx context.Context, s *readStripe, r1, r2 *memberQueue, hedging bool) (int, error) {
+	wait := func(done chan error) (error, bool) {
+		select {
+		case err := <-done:
+			return err, true
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+	var err1, err2 error
+	got1, got2 := false, false
+	late := -1
+	if hedging {
+		timer := time.NewTimer(time.Until(s.issued.Add(self.array.HedgeAfter)))
+		expired := timer.C
+		for !(got1 && got2) && late < 0 {
+			select {
+			case err1 = <-s.done1:
+				got1 = true
+			case err2 = <-s.done2:
+				got2 = true
+			case <-expired:
+				//past time, the next one to show up is the fast one
+				expired = nil
+			case <-ctx.Done():
+				timer.Stop()
+				return -1, ctx.Err()
+			}
+			switch {
+			case expired != nil || got1 == got2:
+			case got1:
+				late = r2.member
+			default:
+				late = r1.member
+			}
+		}
+		timer.Stop()
+	}
+	if late >= 0 {
+		//the slow one's buffer still belongs to its read, parity goes
+		//into a new one
+		buf := make([]byte, HALF_BLOCK)
+		perr, ok := wait(self.queues[MEMBER_PARITY].read(ctx, buf, s.pos))
+		if !ok || (perr != nil && ctx.Err() != nil) {
+			return -1, ctx.Err()
+		}
+		if perr != nil {
+			//parity is no help, so wait for the slow one after all
+			self.memberError(MEMBER_PARITY, "read", perr)
+		} else if late == r1.member {
+			if !got2 {
+				if err2, got2 = wait(s.done2); !got2 {
+					return -1, ctx.Err()
+				}
+			}
+			if err2 == nil {
+				parity.XOR(buf, buf, s.data2)
+				s.data1, got1 = buf, true
+			}
+		} else {
+			if err1 == nil {
+				parity.XOR(buf, s.data1, buf)
+				s.data2, got2 = buf, true
+			}
+		}
+		if got1 && got2 && err1 == nil && err2 == nil {
+			s.hedged = late
+			self.array.stats.hedgedReads.Add(1)
+			self.array.stats.reconstructedBytes.Add(HALF_BLOCK)
+		}
+	}
+	if !got1 {
+		if err1, got1 = wait(s.done1); !got1 {
+			return -1, ctx.Err()
+		}
+	}
+	if err1 != nil {
+		return r1.member, err1
+	}
+	if !got2 {
+		if err2, got2 = wait(s.done2); !got2 {
+			return -1, ctx.Err()
+		}
+	}
+	if err2 != nil {
+		return r2.member, err2
+	}
+	return -1, nil
+}
@@ -0,0 +1,112 @@
+package raid5
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+//how long reads from each member of an array take at least, see
+//delayReads
+var readDelays sync.Map
+
+func init() {
+	beforeRead = func(array *Array, member int) {
+		if delays, ok := readDelays.Load(array); ok {
+			time.Sleep(delays.([3]time.Duration)[member])
+		}
+	}
+}
+
+//make every read from member take at least d, until the test is done
+func delayReads(t *testing.T, array *Array, member int, d time.Duration) {
+	var delays [3]time.Duration
+	if old, ok := readDelays.Load(array); ok {
+		delays = old.([3]time.Duration)
+	}
+	delays[member] = d
+	readDelays.Store(array, delays)
+	t.Cleanup(func() { readDelays.Delete(array) })
+}
+
+func TestHedgedReads(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	content := bytes.Repeat([]byte("slowpoke "), 4*BLOCK_SIZE/9)
+	writeTestObject(t, array, "slow", content)
+
+	delayReads(t, array, MEMBER_D2, 200*time.Millisecond)
+	array.HedgeAfter = 10 * time.Millisecond
+	obj, err := array.OpenFile("slow")
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	got := make([]byte, obj.Size())
+	start := time.Now()
+	if _, err := obj.ReadFile(got, 0); err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	elapsed := time.Since(start)
+	//waits for the slow reads to finish
+	obj.Close()
+	if !bytes.Equal(got, content) {
+		t.Errorf("wrong content read back")
+	}
+	if elapsed >= 200*time.Millisecond {
+		t.Errorf("read waited for the slow member: %v", elapsed)
+	}
+	stats := array.Stats()
+	if stats.HedgedReads != 4 {
+		t.Errorf("expected every stripe to be hedged, got %d", stats.HedgedReads)
+	}
+	//the rest of d2's reads are called off once it falls behind
+	if stats.MemberReads[MEMBER_D2] != 1 || stats.MemberReadTime[MEMBER_D2] < 200*time.Millisecond {
+		t.Errorf("slow reads weren't tracked: %d took %v", stats.MemberReads[MEMBER_D2], stats.MemberReadTime[MEMBER_D2])
+	}
+	if stats.MemberReads[MEMBER_PARITY] != 4 {
+		t.Errorf("expected parity to be read for every stripe, got %d", stats.MemberReads[MEMBER_PARITY])
+	}
+}
+
+//with more stripes than fit in a member's queue, the slow member
+//mustn't set the pace once the queue fills up
+func TestHedgedReadsPastQueueDepth(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	stripes := 5 * QUEUE_DEPTH
+	content := bytes.Repeat([]byte("a long way "), stripes*BLOCK_SIZE/11)
+	writeTestObject(t, array, "long", content)
+
+	delayReads(t, array, MEMBER_D2, 100*time.Millisecond)
+	array.HedgeAfter = 5 * time.Millisecond
+	obj, err := array.OpenFile("long")
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	got := make([]byte, obj.Size())
+	start := time.Now()
+	if _, err := obj.ReadFile(got, 0); err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	elapsed := time.Since(start)
+	obj.Close()
+	if !bytes.Equal(got, content) {
+		t.Errorf("wrong content read back")
+	}
+	//reading through d2 would be 4s, a queue full of it is 800ms
+	if elapsed >= 500*time.Millisecond {
+		t.Errorf("read of %d stripes waited for the slow member: %v", stripes, elapsed)
+	}
+	stats := array.Stats()
+	if stats.MemberReads[MEMBER_D2] > 2 {
+		t.Errorf("kept reading from the slow member: %d reads", stats.MemberReads[MEMBER_D2])
+	}
+	if int(stats.HedgedReads) != (len(content)+BLOCK_SIZE-1)/BLOCK_SIZE {
+		t.Errorf("expected every stripe to be hedged, got %d", stats.HedgedReads)
+	}
+}
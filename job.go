@@ -0,0 +1,108 @@
+package raid5
+
+import (
+	"fmt"
+	"time"
+)
+
+//how many finished jobs Jobs() remembers
+const MAX_FINISHED_JOBS = 16
+
+//Job is a snapshot of some long running piece of work that the array is
+//doing in the background, like rebuilding a member.
+type Job struct {
+	ID   int
+	Kind string
+	//the member being worked on and its directory, -1 and "" for jobs
+	//that work on the whole array
+	Member int
+	Dir    string
+	//objects to get through, how many are done, how many of those
+	//couldn't be done and how many had something fixed
+	Total    int
+	Done     int
+	Failed   int
+	Repaired int
+	//the first few things that went wrong, the rest are just logged
+	Problems []string
+
+	Started time.Time
+	//zero while the job is running
+	Finished time.Time
+	//why the job stopped early, if it did
+	Error string
+}
+
+func (self *Job) Running() bool {
+	return self.Finished.IsZero()
+}
+
+//Jobs reports everything running and the last few jobs that finished,
+//oldest first.
+func (self *Array) Jobs() []Job {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	result := make([]Job, len(self.jobs))
+	for i, job := range self.jobs {
+		result[i] = *job
+		result[i].Problems = append([]string{}, job.Problems...)
+	}
+	return result
+}
+
+//Wait blocks until all the jobs running in the background are done.
+func (self *Array) Wait() {
+	self.running.Wait()
+}
+
+//called with the lock held.  only one job of a kind can be working on
+//a member directory at a time.
+func (self *Array) startJob(kind string, member int, dir string) (*Job, error) {
+	id := 1
+	for _, job := range self.jobs {
+		if job.Running() && job.Kind == kind && job.Dir == dir {
+			if member < 0 {
+				return nil, fmt.Errorf("already doing a %s", kind)
+			}
+			return nil, fmt.Errorf("already doing a %s of member %s (%s)", kind, memberNames[member], dir)
+		}
+		id = job.ID + 1
+	}
+	result := &Job{
+		ID:      id,
+		Kind:    kind,
+		Member:  member,
+		Dir:     dir,
+		Started: time.Now(),
+	}
+	self.jobs = append(self.jobs, result)
+	return result, nil
+}
+
+//how many problems a job keeps for people to look at
+const MAX_JOB_PROBLEMS = 100
+
+//called with the lock held
+func (self *Job) problem(err error) {
+	if len(self.Problems) < MAX_JOB_PROBLEMS {
+		self.Problems = append(self.Problems, err.Error())
+	}
+}
+
+//called with the lock held
+func (self *Array) finishJob(job *Job, err error) {
+	job.Finished = time.Now()
+	if err != nil {
+		job.Error = err.Error()
+	}
+	finished := 0
+	for i := len(self.jobs) - 1; i >= 0; i-- {
+		if self.jobs[i].Running() {
+			continue
+		}
+		finished++
+		if finished > MAX_FINISHED_JOBS {
+			self.jobs = append(self.jobs[:i], self.jobs[i+1:]...)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package raid5
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//LockManager hands out readers/writer locks on objects.  CreateFile
+//holds the writer side until the object is completely written (or
+//closed) and OpenFile holds the reader side until Close(), so readers
+//never see an object that is half way through WriteAndClose().
+type LockManager struct {
+	//if set, locks are also taken with flock(2) on a "name.lock" file
+	//next to the object in the first member directory that has one,
+	//so that other processes working on the same array see them too.
+	LockFiles bool
+
+	mu    sync.Mutex
+	locks map[string]*objectLock
+}
+
+//one of these per object that somebody is holding or waiting for
+type objectLock struct {
+	sync.RWMutex
+	refs int
+}
+
+//Locks is used by CreateFile and OpenFile.
+var Locks = NewLockManager()
+
+func NewLockManager() *LockManager {
+	return &LockManager{locks: make(map[string]*objectLock)}
+}
+
+//heldLock is what you get back from the manager, Unlock it when done.
+//it's safe to Unlock more than once.
+type heldLock struct {
+	manager   *LockManager
+	key       string
+	exclusive bool
+	file      *os.File
+	once      sync.Once
+}
+
+//take the lock for the object at path (already converted by keyPath)
+//in the given member directories.  array is the in-process key for
+//the array, so that different arrays don't collide.
+func (self *LockManager) lock(array string, dirs []string, path string, exclusive bool) (*heldLock, error) {
+	return self.lockContext(context.Background(), array, dirs, path, exclusive)
+}
+
+//lock, giving up if ctx is done before the lock can be had, both the
+//wait for other goroutines and (with LockFiles) for other processes.
+//a lock that turns up after that is let go again straight away.
+func (self *LockManager) lockContext(ctx context.Context, array string, dirs []string, path string, exclusive bool) (*heldLock, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	key := filepath.Join(array, path)
+
+	self.mu.Lock()
+	l, ok := self.locks[key]
+	if !ok {
+		l = &objectLock{}
+		self.locks[key] = l
+	}
+	l.refs++
+	self.mu.Unlock()
+
+	result := &heldLock{manager: self, key: key, exclusive: exclusive}
+	take := l.Lock
+	if !exclusive {
+		take = l.RLock
+	}
+	if ctx.Done() == nil {
+		take()
+	} else {
+		got := make(chan struct{})
+		go func() {
+			take()
+			close(got)
+		}()
+		select {
+		case <-got:
+		case <-ctx.Done():
+			//it's ours when it turns up, and we don't want it any more
+			go func() {
+				<-got
+				result.Unlock()
+			}()
+			return nil, ctx.Err()
+		}
+		//both were ready and select picked this one
+		if err := ctx.Err(); err != nil {
+			result.Unlock()
+			return nil, err
+		}
+	}
+
+	if self.LockFiles {
+		f, err := lockFileContext(ctx, dirs, path, exclusive)
+		if err != nil {
+			result.Unlock()
+			return nil, err
+		}
+		result.file = f
+	}
+	return result, nil
+}
+
+func (self *heldLock) Unlock() {
+	self.once.Do(func() {
+		if self.file != nil {
+			unlockFile(self.file)
+			self.file.Close()
+		}
+		m := self.manager
+		m.mu.Lock()
+		l := m.locks[self.key]
+		l.refs--
+		if l.refs == 0 {
+			delete(m.locks, self.key)
+		}
+		m.mu.Unlock()
+		if self.exclusive {
+			l.Unlock()
+		} else {
+			l.RUnlock()
+		}
+	})
+}
+
+//called with the lock held by RemoveFile, the object is gone so its
+//lock file can go too.  anybody already waiting on it notices that
+//it's been removed once they get it, see lockFile.
+func (self *heldLock) removeFile() {
+	if self.file != nil {
+		os.Remove(self.file.Name())
+	}
+}
+
+//lockFile, giving up if ctx is done first.  flock can't be
+//interrupted, so the wait carries on in the background and the lock
+//file is unlocked and closed if it's gotten after all.
+func lockFileContext(ctx context.Context, dirs []string, path string, exclusive bool) (*os.File, error) {
+	if ctx.Done() == nil {
+		return lockFile(dirs, path, exclusive)
+	}
+	type locked struct {
+		f   *os.File
+		err error
+	}
+	got := make(chan locked, 1)
+	go func() {
+		f, err := lockFile(dirs, path, exclusive)
+		got <- locked{f, err}
+	}()
+	select {
+	case l := <-got:
+		if err := ctx.Err(); err != nil && l.f != nil {
+			unlockFile(l.f)
+			l.f.Close()
+			return nil, err
+		}
+		return l.f, l.err
+	case <-ctx.Done():
+		go func() {
+			if l := <-got; l.f != nil {
+				unlockFile(l.f)
+				l.f.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+//open (creating if needed) the lock file for path and flock it.  if
+//the directory the lock file goes in doesn't exist in any member then
+//there is no object to protect and we return nil.
+func lockFile(dirs []string, path string, exclusive bool) (*os.File, error) {
+	var firstErr error
+	for _, dir := range dirs {
+		name := filepath.Join(dir, path+".lock")
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if err := flockFile(f, exclusive); err != nil {
+			f.Close()
+			return nil, err
+		}
+		//if RemoveFile took the lock file away while we waited, somebody
+		//else may have locked a new one, so start over with that
+		held, err := f.Stat()
+		if err == nil {
+			var now os.FileInfo
+			if now, err = os.Stat(name); err == nil && !os.SameFile(held, now) {
+				err = os.ErrNotExist
+			}
+		}
+		if err != nil {
+			unlockFile(f)
+			f.Close()
+			if os.IsNotExist(err) {
+				return lockFile(dirs, path, exclusive)
+			}
+			return nil, err
+		}
+		return f, nil
+	}
+	if os.IsNotExist(firstErr) {
+		return nil, nil
+	}
+	return nil, firstErr
+}
@@ -0,0 +1,229 @@
+package raid5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentReads(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	content := bytes.Repeat([]byte("concurrent"), BLOCK_SIZE/3)
+	result, err := CreateFile(d1, d2, parity, "shared")
+	if err != nil {
+		t.Fatalf("failed to create files: %v", err)
+	}
+	if _, _, err := result.WriteAndClose(content); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	//one open object, lots of readers on it
+	obj, err := OpenFile(d1, d2, parity, "shared")
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer obj.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out := make([]byte, obj.Size())
+			if _, err := obj.ReadFile(out, 0); err != nil {
+				t.Errorf("failed concurrent read: %v", err)
+				return
+			}
+			if !bytes.Equal(out, content) {
+				t.Errorf("concurrent read returned the wrong content")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestOpenWaitsForWriter(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	content := []byte("not done yet")
+	result, err := CreateFile(d1, d2, parity, "slowpoke")
+	if err != nil {
+		t.Fatalf("failed to create files: %v", err)
+	}
+
+	opened := make(chan *raid5File)
+	go func() {
+		obj, err := OpenFile(d1, d2, parity, "slowpoke")
+		if err != nil {
+			t.Errorf("failed to open after the write: %v", err)
+		}
+		opened <- obj
+	}()
+
+	select {
+	case <-opened:
+		t.Fatalf("open should not finish while the object is being written")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, _, err := result.WriteAndClose(content); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	obj := <-opened
+	if obj == nil {
+		return
+	}
+	defer obj.Close()
+	if obj.Size() != int64(len(content)) {
+		t.Errorf("reader saw the wrong size %d", obj.Size())
+	}
+}
+
+func TestConcurrentCreate(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created, existed := 0, 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := CreateFile(d1, d2, parity, "popular")
+			mu.Lock()
+			defer mu.Unlock()
+			if os.IsExist(err) {
+				existed++
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected create error: %v", err)
+				return
+			}
+			created++
+			if _, _, err := result.WriteAndClose([]byte("me first")); err != nil {
+				t.Errorf("failed to write: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if created != 1 || existed != 7 {
+		t.Errorf("expected exactly one create to win, got %d created and %d existed", created, existed)
+	}
+}
+
+func TestLockFilesExcludeOtherManagers(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+	dirs := []string{d1, d2, parity}
+
+	//two managers stand in for two processes
+	first, second := NewLockManager(), NewLockManager()
+	first.LockFiles = true
+	second.LockFiles = true
+
+	held, err := first.lock(d1, dirs, "thing", true)
+	if err != nil {
+		t.Fatalf("failed to lock: %v", err)
+	}
+	got := make(chan *heldLock)
+	go func() {
+		l, err := second.lock(d1, dirs, "thing", false)
+		if err != nil {
+			t.Errorf("failed to lock from second manager: %v", err)
+		}
+		got <- l
+	}()
+	select {
+	case <-got:
+		t.Fatalf("second manager got the lock while the first held it")
+	case <-time.After(50 * time.Millisecond):
+	}
+	held.Unlock()
+	if l := <-got; l != nil {
+		l.Unlock()
+	}
+
+	//lock files aren't objects
+	names, err := ListFiles(d1, d2, parity, "")
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	if len(names) != 0 {
+		t.Errorf("lock files showed up as objects: %v", names)
+	}
+}
+
+func TestLockFileGivesUpWaiting(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+	dirs := []string{d1, d2, parity}
+
+	first, second := NewLockManager(), NewLockManager()
+	first.LockFiles = true
+	second.LockFiles = true
+
+	held, err := first.lock(d1, dirs, "thing", true)
+	if err != nil {
+		t.Fatalf("failed to lock: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := second.lockContext(ctx, d1, dirs, "thing", true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the lock to give up, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("waited for the other process: %v", elapsed)
+	}
+	held.Unlock()
+
+	//the flock the second manager gave up on gets let go when it turns up
+	got := make(chan *heldLock)
+	go func() {
+		l, err := first.lock(d1, dirs, "thing", true)
+		if err != nil {
+			t.Errorf("failed to lock again: %v", err)
+		}
+		got <- l
+	}()
+	select {
+	case l := <-got:
+		if l != nil {
+			l.Unlock()
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("lock file is stuck")
+	}
+}
+
+func TestRemoveTakesLockFile(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	Locks.LockFiles = true
+	defer func() { Locks.LockFiles = false }()
+	array := NewArray(d1, d2, parity)
+	writeTestObject(t, array, "doomed", []byte("not for long"))
+	readTestObject(t, array, "doomed")
+	if _, err := os.Stat(filepath.Join(d1, "doomed.lock")); err != nil {
+		t.Fatalf("expected a lock file: %v", err)
+	}
+	if err := array.RemoveFile("doomed"); err != nil {
+		t.Fatalf("failed to remove: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(d1, "doomed.lock")); !os.IsNotExist(err) {
+		t.Errorf("lock file left behind: %v", err)
+	}
+	//and the name can be used again
+	writeTestObject(t, array, "doomed", []byte("back again"))
+}
@@ -0,0 +1,165 @@
+package raid5
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+//an object's metadata is kept as JSON, at the object's name, under
+//.raid5/meta in each of the members that has a leg of it.  it's small
+//enough that there's no point in striping it.
+func metaPath(dir string, path string) string {
+	return filepath.Join(metaRoot(dir), path)
+}
+
+//where the metadata of all the objects in a member is kept
+func metaRoot(dir string) string {
+	return filepath.Join(dir, RESERVED_DIR, "meta")
+}
+
+//SetMetadata gives a file from CreateFile some strings to keep with it,
+//like the content type a server should send it back with.  it has to
+//be called before WriteAndClose or Close to have any effect.
+func (self *raid5File) SetMetadata(meta map[string]string) {
+	self.metadata = meta
+}
+
+//Metadata returns what was given to SetMetadata when the object was
+//written, nil if there wasn't anything.  for a file from OpenFile it's
+//read from the first member that has it.
+func (self *raid5File) Metadata() (map[string]string, error) {
+	if self.creating || self.metaLoaded {
+		return self.metadata, nil
+	}
+	path := filepath.FromSlash(self.startingName)
+	var result error
+	for i, dir := range self.dirs {
+		if !self.has(i) {
+			continue
+		}
+		meta, err := readMeta(metaPath(dir, path))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			log.Printf("unable to read metadata of %s from member %s: %v", self.startingName, memberNames[i], err)
+			result = err
+			continue
+		}
+		self.metadata, self.metaLoaded = meta, true
+		return meta, nil
+	}
+	if result != nil {
+		return nil, result
+	}
+	self.metaLoaded = true
+	return nil, nil
+}
+
+func readMeta(file string) (map[string]string, error) {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string)
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("metadata in %s: %v", file, err)
+	}
+	return result, nil
+}
+
+//called by WriteAndClose and Close before the object can be seen, in
+//each member that's getting a leg.  whatever an earlier object with the
+//same name left behind goes, even when there's nothing to write.
+func (self *raid5File) writeMeta() error {
+	var raw []byte
+	if len(self.metadata) > 0 {
+		var err error
+		if raw, err = json.Marshal(self.metadata); err != nil {
+			return err
+		}
+	}
+	path := filepath.FromSlash(self.startingName)
+	for i, dir := range self.dirs {
+		if !self.has(i) {
+			continue
+		}
+		file := metaPath(dir, path)
+		if raw == nil {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				return self.memberError(i, "remove metadata", err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return self.memberError(i, "mkdir", err)
+		}
+		err := writeMetaFile(file, raw, self.durability)
+		//a RemoveFile next door can prune the directory we just made
+		if os.IsNotExist(err) {
+			if err = os.MkdirAll(filepath.Dir(file), 0755); err == nil {
+				err = writeMetaFile(file, raw, self.durability)
+			}
+		}
+		if err != nil {
+			return self.memberError(i, "write metadata", err)
+		}
+	}
+	return nil
+}
+
+func writeMetaFile(file string, raw []byte, durability Durability) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(raw)
+	if err == nil && durability >= DURABILITY_FSYNC {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && durability >= DURABILITY_FSYNC_DIRS {
+		err = syncDir(filepath.Dir(file))
+	}
+	return err
+}
+
+//take the metadata of an object out of one member, errors are only
+//logged since it's no use without the object anyway
+func removeMeta(dir string, path string) {
+	file := metaPath(dir, path)
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		log.Printf("unable to remove metadata %s: %v", file, err)
+	}
+}
+
+//put the metadata of an object being rebuilt onto member back, from
+//whichever of the other members has it
+func (self *Array) copyMeta(member int, dir string, dirs []string, sources []int, path string) error {
+	removeMeta(dir, path)
+	for _, source := range sources {
+		raw, err := ioutil.ReadFile(metaPath(dirs[source], path))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			log.Printf("unable to read metadata %s: %v", metaPath(dirs[source], path), err)
+			continue
+		}
+		file := metaPath(dir, path)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return self.memberErrorIn(dir, member, "mkdir", err)
+		}
+		if err := writeMetaFile(file, raw, DURABILITY_FSYNC_DIRS); err != nil {
+			return self.memberErrorIn(dir, member, "write metadata", err)
+		}
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package raid5
+
+import (
+	"os"
+	"testing"
+)
+
+func writeTestMetadata(t *testing.T, array *Array, name string, content []byte, meta map[string]string) {
+	obj, err := array.CreateFile(name)
+	if err != nil {
+		t.Fatalf("failed to create %s: %v", name, err)
+	}
+	obj.SetMetadata(meta)
+	if len(content) == 0 {
+		err = obj.Close()
+	} else {
+		_, _, err = obj.WriteAndClose(content)
+	}
+	if err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+}
+
+func readTestMetadata(t *testing.T, array *Array, name string) map[string]string {
+	obj, err := array.OpenFile(name)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", name, err)
+	}
+	defer obj.Close()
+	meta, err := obj.Metadata()
+	if err != nil {
+		t.Fatalf("failed to read metadata of %s: %v", name, err)
+	}
+	return meta
+}
+
+func TestMetadata(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	meta := map[string]string{"Content-Type": "text/plain", "X-Whatever": "yes"}
+	writeTestMetadata(t, array, "dir/typed", []byte("some text"), meta)
+	writeTestMetadata(t, array, "empty", nil, meta)
+	writeTestObject(t, array, "plain", []byte("no metadata"))
+
+	for _, name := range []string{"dir/typed", "empty"} {
+		got := readTestMetadata(t, array, name)
+		if len(got) != 2 || got["Content-Type"] != "text/plain" || got["X-Whatever"] != "yes" {
+			t.Errorf("wrong metadata for %s: %v", name, got)
+		}
+	}
+	if got := readTestMetadata(t, array, "plain"); got != nil {
+		t.Errorf("expected no metadata, got %v", got)
+	}
+
+	//a member that comes back empty gets it back from the others
+	if err := os.RemoveAll(d1); err != nil {
+		t.Fatalf("failed to remove %s: %v", d1, err)
+	}
+	if err := os.Mkdir(d1, 0755); err != nil {
+		t.Fatalf("failed to recreate %s: %v", d1, err)
+	}
+	if err := array.Rebuild(MEMBER_D1); err != nil {
+		t.Fatalf("failed to rebuild: %v", err)
+	}
+	if _, err := os.Stat(metaPath(d1, "dir/typed")); err != nil {
+		t.Errorf("metadata wasn't rebuilt: %v", err)
+	}
+
+	//it goes with the object, and doesn't come back with a new one
+	if err := array.RemoveFile("dir/typed"); err != nil {
+		t.Fatalf("failed to remove: %v", err)
+	}
+	for _, dir := range []string{d1, d2, parity} {
+		if _, err := os.Stat(metaPath(dir, "dir/typed")); !os.IsNotExist(err) {
+			t.Errorf("metadata left behind in %s: %v", dir, err)
+		}
+	}
+	writeTestObject(t, array, "dir/typed", []byte("untyped now"))
+	if got := readTestMetadata(t, array, "dir/typed"); got != nil {
+		t.Errorf("expected no metadata for the new object, got %v", got)
+	}
+}
@@ -0,0 +1,209 @@
+package raid5
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+var (
+	ErrInvalidName = errors.New("invalid object name")
+)
+
+const (
+	MAX_NAME_LENGTH = 1024
+	//the last piece of a name gets "$len$hash" glued on the end of it
+	//on disk (up to 53 bytes), and most filesystems stop at 255
+	MAX_NAME_PIECE_LENGTH = 200
+)
+
+//suffixes the library keeps for its own files that live next to the
+//objects in a member directory (lock files, for example)
+var reservedSuffixes = []string{".lock"}
+
+//NameError explains why a name was refused.  it matches ErrInvalidName
+//with errors.Is so callers don't have to care about the details.
+type NameError struct {
+	Name   string
+	Reason string
+}
+
+func (self *NameError) Error() string {
+	return fmt.Sprintf("invalid object name %q: %s", self.Name, self.Reason)
+}
+
+func (self *NameError) Unwrap() error {
+	return ErrInvalidName
+}
+
+//ValidateName checks that name is acceptable as an object name without
+//touching the disk.  every entry point into the library does this, but
+//servers may want to do it up front to reject requests early.
+func ValidateName(name string) error {
+	bad := func(reason string) error {
+		return &NameError{Name: name, Reason: reason}
+	}
+	if name == "" {
+		return bad("empty")
+	}
+	if len(name) > MAX_NAME_LENGTH {
+		return bad(fmt.Sprintf("longer than %d bytes", MAX_NAME_LENGTH))
+	}
+	if strings.IndexByte(name, 0) != -1 {
+		return bad("contains NUL")
+	}
+	//used to separate the metadata in the underlying name
+	if strings.Index(name, "$") != -1 {
+		return bad("contains $")
+	}
+	if strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") {
+		return bad("starts or ends with /")
+	}
+	if name == RESERVED_DIR || strings.HasPrefix(name, RESERVED_DIR+"/") {
+		return bad("inside the reserved directory " + RESERVED_DIR)
+	}
+	for _, piece := range strings.Split(name, "/") {
+		//empty piece is "a//b", the dot ones are the path traversal
+		//cases we really care about
+		if piece == "" || piece == "." || piece == ".." {
+			return bad("empty, . or .. path element")
+		}
+		if strings.IndexRune(piece, filepath.Separator) != -1 {
+			return bad("contains a path separator")
+		}
+		if len(piece) > MAX_NAME_PIECE_LENGTH {
+			return bad(fmt.Sprintf("path element longer than %d bytes", MAX_NAME_PIECE_LENGTH))
+		}
+		for _, suffix := range reservedSuffixes {
+			if strings.HasSuffix(piece, suffix) {
+				return bad("reserved suffix " + suffix)
+			}
+		}
+	}
+	return nil
+}
+
+//object names are "keys" that may contain slashes, like a path.  each
+//key is stored at the same relative path in every member directory,
+//so "photos/2013/dog.jpg" ends up in d1/photos/2013, d2/photos/2013
+//and parity/photos/2013.  this is the only place that turns a key into
+//a relative filesystem path, everything else should go through here.
+func keyPath(name string) (string, error) {
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	return filepath.FromSlash(name), nil
+}
+
+//errors from a name running into another object in the member dir,
+//like "a/b" when "a" is an object.  they're the name's fault and not
+//the member's, so they don't count against its health.  the member
+//itself not being a directory any more is another story.
+func nameConflict(dir string, err error) bool {
+	if !errors.Is(err, syscall.ENOTDIR) && !errors.Is(err, syscall.EISDIR) && !errors.Is(err, syscall.EEXIST) {
+		return false
+	}
+	info, statErr := os.Stat(dir)
+	return statErr == nil && info.IsDir()
+}
+
+//make sure the directories that hold a key exist in a member
+func makeKeyDir(dir string, path string) error {
+	parent := filepath.Dir(path)
+	if parent == "." {
+		return nil
+	}
+	return os.MkdirAll(filepath.Join(dir, parent), 0755)
+}
+
+//take the directories that held a key in a member back out, as far up
+//as they're empty, so the names they were in the way of are free
+//again.  root is where to stop, the member dir or its metadata dir.
+//it returns the directory that changed last, the one to sync.
+func pruneKeyDir(root string, path string) string {
+	parent := filepath.Dir(path)
+	for ; parent != "."; parent = filepath.Dir(parent) {
+		if err := os.Remove(filepath.Join(root, parent)); err != nil {
+			break
+		}
+	}
+	return filepath.Join(root, parent)
+}
+
+//ListFiles returns the names of all objects whose name starts with
+//prefix, sorted.  like OpenFile, an object is only considered present
+//if at least two of the three members know about it.
+func ListFiles(d1, d2, parity, prefix string) ([]string, error) {
+	return NewArray(d1, d2, parity).ListFiles(prefix)
+}
+
+//ListFiles is the same as the function, for the array's members
+func (self *Array) ListFiles(prefix string) ([]string, error) {
+	//no need to walk the whole tree, start at the deepest directory that
+	//is entirely covered by the prefix
+	start := ""
+	if i := strings.LastIndex(prefix, "/"); i != -1 {
+		start = prefix[:i]
+		if _, err := keyPath(start); err != nil {
+			return nil, err
+		}
+	}
+
+	counts := make(map[string]int)
+	for i, dir := range self.Dirs() {
+		//no point in poking at a dead disk
+		if !self.writable(i) {
+			continue
+		}
+		root := filepath.Join(dir, filepath.FromSlash(start))
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil //this member doesn't have anything here
+				}
+				return err
+			}
+			if info.IsDir() {
+				if path == filepath.Join(dir, RESERVED_DIR) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			//encoded names are the real content, they are reached through
+			//the symlink with the plain name
+			if strings.Index(info.Name(), "$") != -1 {
+				return nil
+			}
+			for _, suffix := range reservedSuffixes {
+				if strings.HasSuffix(info.Name(), suffix) {
+					return nil
+				}
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			name := filepath.ToSlash(rel)
+			if strings.HasPrefix(name, prefix) && self.usable(i, name) {
+				counts[name]++
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := []string{}
+	for name, ct := range counts {
+		if ct >= 2 {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
@@ -0,0 +1,166 @@
+package raid5
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyPathRejectsTraversal(t *testing.T) {
+	bad := []string{"", "/etc/passwd", "../x", "a/../../x", "a/./b", "a//b", "a/", ".."}
+	for _, name := range bad {
+		if _, err := keyPath(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("expected %q to be rejected but got %v", name, err)
+		}
+	}
+	good := []string{"a", "a/b/c", "...", ".hidden/x"}
+	for _, name := range good {
+		if _, err := keyPath(name); err != nil {
+			t.Errorf("expected %q to be ok but got %v", name, err)
+		}
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	bad := []string{"nul\x00byte", "money$", strings.Repeat("x", MAX_NAME_LENGTH+1),
+		strings.Repeat("y", MAX_NAME_PIECE_LENGTH+1), "a/b.lock"}
+	for _, name := range bad {
+		err := ValidateName(name)
+		if !errors.Is(err, ErrInvalidName) {
+			t.Errorf("expected %q to be rejected but got %v", name, err)
+		}
+		var nameErr *NameError
+		if !errors.As(err, &nameErr) || nameErr.Name != name {
+			t.Errorf("expected a NameError for %q but got %v", name, err)
+		}
+	}
+	if err := ValidateName("a.lock/b"); err == nil {
+		t.Errorf("expected reserved suffix on a directory to be rejected")
+	}
+	if err := ValidateName("lock"); err != nil {
+		t.Errorf("unexpected error for plain name: %v", err)
+	}
+
+	//the library should refuse these rather than blowing up
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+	if _, err := CreateFile(d1, d2, parity, "foo$bar"); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("expected CreateFile to refuse a $ in the name, got %v", err)
+	}
+	if _, err := OpenFile(d1, d2, parity, ""); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("expected OpenFile to refuse an empty name, got %v", err)
+	}
+}
+
+func TestNestedNameRoundTrip(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	name := "photos/2013/dog"
+	content := []byte("woof woof woof")
+	result, err := CreateFile(d1, d2, parity, name)
+	if err != nil {
+		t.Fatalf("failed to create nested file: %v", err)
+	}
+	if _, _, err := result.WriteAndClose(content); err != nil {
+		t.Fatalf("failed to write nested file: %v", err)
+	}
+
+	//the content has to be in the nested directory of every member
+	for _, dir := range []string{d1, d2, parity} {
+		link := filepath.Join(dir, "photos", "2013", "dog")
+		if _, err := os.Readlink(link); err != nil {
+			t.Errorf("expected a symlink at %s: %v", link, err)
+		}
+	}
+
+	//lose a data leg to be sure the nested metadata decodes properly
+	if err := os.Remove(filepath.Join(d1, result.finalName)); err != nil {
+		t.Fatalf("could not delete file: %v", err)
+	}
+	result, err = OpenFile(d1, d2, parity, name)
+	if err != nil {
+		t.Fatalf("failed to open nested file: %v", err)
+	}
+	compare := make([]byte, result.Size())
+	if _, err := result.ReadFile(compare, 0); err != nil {
+		t.Fatalf("failed to read nested file: %v", err)
+	}
+	if string(compare) != string(content) {
+		t.Errorf("wrong content read back: %q", compare)
+	}
+}
+
+func TestListFilesByPrefix(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	for _, name := range []string{"a/x", "a/y", "a/b/z", "ab", "c"} {
+		result, err := CreateFile(d1, d2, parity, name)
+		if err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+		if _, _, err := result.WriteAndClose([]byte(name)); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	expected := map[string][]string{
+		"":    {"a/b/z", "a/x", "a/y", "ab", "c"},
+		"a":   {"a/b/z", "a/x", "a/y", "ab"},
+		"a/":  {"a/b/z", "a/x", "a/y"},
+		"a/b": {"a/b/z"},
+		"zz":  {},
+	}
+	for prefix, names := range expected {
+		found, err := ListFiles(d1, d2, parity, prefix)
+		if err != nil {
+			t.Fatalf("failed to list %q: %v", prefix, err)
+		}
+		if len(found) != len(names) {
+			t.Errorf("wrong names for prefix %q: %v", prefix, found)
+			continue
+		}
+		for i := range names {
+			if found[i] != names[i] {
+				t.Errorf("wrong names for prefix %q: %v", prefix, found)
+				break
+			}
+		}
+	}
+
+	if _, err := ListFiles(d1, d2, parity, "../"); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("expected traversal in a prefix to be rejected, got %v", err)
+	}
+}
+
+func TestNameRunsIntoObject(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	writeTestObject(t, array, "a", []byte("in the way"))
+	writeTestObject(t, array, "c/d", []byte("also in the way"))
+	for i := 0; i < 2*MAX_MEMBER_ERRORS; i++ {
+		if _, err := array.CreateFile("a/b"); !errors.Is(err, os.ErrExist) {
+			t.Errorf("expected a/b to be taken by a, got %v", err)
+		}
+		if _, err := array.OpenFile("a/b"); !os.IsNotExist(err) {
+			t.Errorf("expected a/b not to exist, got %v", err)
+		}
+		if _, err := array.CreateFile("c"); !errors.Is(err, os.ErrExist) {
+			t.Errorf("expected c to be taken by c/d, got %v", err)
+		}
+	}
+	for i, m := range array.Members() {
+		if m.State != MEMBER_ONLINE || m.Errors != 0 {
+			t.Errorf("a bad name counted against member %d: %+v", i, m)
+		}
+	}
+	if !bytes.Equal(readTestObject(t, array, "a"), []byte("in the way")) {
+		t.Errorf("wrong content for a")
+	}
+}
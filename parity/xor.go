@@ -0,0 +1,25 @@
+//Package parity has the XOR kernel that raid5 uses to compute parity
+//on write and to reconstruct a missing leg on read.  this is where
+//nearly all the CPU goes on big writes and degraded reads, so it works
+//on whole machine words rather than a byte at a time.
+package parity
+
+//XOR sets dst[i] = a[i] ^ b[i] for every byte of dst.  a and b must be
+//at least as long as dst.  dst may be the same slice as a or b, which
+//is how a missing leg gets rebuilt in place.
+func XOR(dst, a, b []byte) {
+	n := len(dst)
+	if len(a) < n || len(b) < n {
+		panic("parity: XOR source shorter than destination")
+	}
+	done := xorWords(dst, a[:n], b[:n])
+	xorBytes(dst[done:], a[done:n], b[done:n])
+}
+
+//the plain old loop, for the leftovers that don't fill a word and as
+//the reference the tests compare against
+func xorBytes(dst, a, b []byte) {
+	for i := range dst {
+		dst[i] = a[i] ^ b[i]
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !(386 || amd64 || arm64 || ppc64 || ppc64le || s390x) || purego
+// +build !386,!amd64,!arm64,!ppc64,!ppc64le,!s390x purego
+
+package parity
+
+import (
+	"encoding/binary"
+)
+
+//no unsafe here, the compiler turns these into single loads and stores
+//where it can and it is still correct where it can't
+func xorWords(dst, a, b []byte) int {
+	n := len(dst) &^ 7
+	for i := 0; i < n; i += 8 {
+		binary.LittleEndian.PutUint64(dst[i:],
+			binary.LittleEndian.Uint64(a[i:])^binary.LittleEndian.Uint64(b[i:]))
+	}
+	return n
+}
@@ -0,0 +1,34 @@
+//go:build (386 || amd64 || arm64 || ppc64 || ppc64le || s390x) && !purego
+// +build 386 amd64 arm64 ppc64 ppc64le s390x
+// +build !purego
+
+package parity
+
+import (
+	"unsafe"
+)
+
+//these machines don't mind unaligned loads, so we can just look at the
+//bytes as uint64s.  the loop does 32 bytes per trip to give the CPU
+//several independent loads to work on at once.
+func xorWords(dst, a, b []byte) int {
+	words := len(dst) / 8
+	if words == 0 {
+		return 0
+	}
+	dw := unsafe.Slice((*uint64)(unsafe.Pointer(&dst[0])), words)
+	aw := unsafe.Slice((*uint64)(unsafe.Pointer(&a[0])), words)
+	bw := unsafe.Slice((*uint64)(unsafe.Pointer(&b[0])), words)
+
+	i := 0
+	for ; i+4 <= words; i += 4 {
+		dw[i] = aw[i] ^ bw[i]
+		dw[i+1] = aw[i+1] ^ bw[i+1]
+		dw[i+2] = aw[i+2] ^ bw[i+2]
+		dw[i+3] = aw[i+3] ^ bw[i+3]
+	}
+	for ; i < words; i++ {
+		dw[i] = aw[i] ^ bw[i]
+	}
+	return words * 8
+}
@@ -0,0 +1,172 @@
+package raid5
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+//how many requests can be waiting for one member before whoever is
+//feeding it has to wait.  this is what lets the writer compute parity
+//for the next stripe while the disks are busy with the last one.
+const QUEUE_DEPTH = 8
+
+//one read or write for a member.  writes are always appended to the
+//end of the file, reads say where they want to read from.
+type memberIO struct {
+	buf  []byte
+	off  int64
+	read bool
+	//a read that nobody is waiting for by the time it comes up is
+	//skipped
+	ctx context.Context
+	//reads get their own result, write errors are sticky in the queue
+	done chan error
+}
+
+//only set by tests, which use it to slow down reads from a member
+var beforeRead func(array *Array, member int)
+
+//memberQueue is a goroutine that does all the I/O for one member file,
+//in the order it was asked for.  since each member is (ideally) a
+//different disk, having one of these per member lets all the disks
+//work at the same time.
+type memberQueue struct {
+	file    *raid5File
+	member  int
+	f       *os.File
+	reqs    chan *memberIO
+	pending sync.WaitGroup
+	stopped chan struct{}
+	stop    sync.Once
+
+	mu  sync.Mutex
+	err error //first write error, after that writes are skipped
+}
+
+func newMemberQueue(file *raid5File, member int, f *os.File) *memberQueue {
+	result := &memberQueue{
+		file:    file,
+		member:  member,
+		f:       f,
+		reqs:    make(chan *memberIO, QUEUE_DEPTH),
+		stopped: make(chan struct{}),
+	}
+	go result.run()
+	return result
+}
+
+func (self *memberQueue) run() {
+	defer close(self.stopped)
+	for req := range self.reqs {
+		if req.read && req.ctx.Err() != nil {
+			req.done <- req.ctx.Err()
+		} else if req.read {
+			start := time.Now()
+			if beforeRead != nil {
+				beforeRead(self.file.array, self.member)
+			}
+			n, err := self.f.ReadAt(req.buf, req.off)
+			self.file.array.stats.readTook(self.member, time.Since(start))
+			if err == nil && n != len(req.buf) {
+				err = fmt.Errorf("read %d of %d bytes at %d: %w", n, len(req.buf), req.off, ErrShortRead)
+			}
+			//it's up to the reader to count this against the member,
+			//several reads can fail for the one reason
+			req.done <- err
+		} else if self.writeErr() == nil {
+			n, err := self.f.Write(req.buf)
+			if err == nil && n != len(req.buf) {
+				err = ErrShortWrite
+			}
+			if err != nil {
+				err = self.file.memberError(self.member, "write", err)
+				self.mu.Lock()
+				self.err = err
+				self.mu.Unlock()
+			}
+		}
+		self.pending.Done()
+	}
+}
+
+func (self *memberQueue) writeErr() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.err
+}
+
+//queue up a write.  buf must not be touched until flush() returns.
+//the error returned is from some earlier write, if any.
+func (self *memberQueue) write(buf []byte) error {
+	if err := self.writeErr(); err != nil {
+		return err
+	}
+	self.pending.Add(1)
+	self.reqs <- &memberIO{buf: buf}
+	return nil
+}
+
+//queue up a read, the result shows up on the returned channel.  if
+//ctx is done before the read gets its turn, the result is ctx.Err().
+func (self *memberQueue) read(ctx context.Context, buf []byte, off int64) chan error {
+	done := make(chan error, 1)
+	self.pending.Add(1)
+	self.reqs <- &memberIO{buf: buf, off: off, read: true, ctx: ctx, done: done}
+	return done
+}
+
+//wait for everything queued so far to hit the file
+func (self *memberQueue) flush() error {
+	self.pending.Wait()
+	return self.writeErr()
+}
+
+//flush and then shut down the goroutine, safe to call more than once
+func (self *memberQueue) close() error {
+	err := self.flush()
+	self.stop.Do(func() {
+		close(self.reqs)
+		<-self.stopped
+	})
+	return err
+}
+
+//start a queue for each of the files that we have, missing ones are nil
+func (self *raid5File) startQueues() {
+	self.queues = make([]*memberQueue, 3)
+	for i, f := range []*os.File{self.f1, self.f2, self.parity} {
+		if f != nil {
+			self.queues[i] = newMemberQueue(self, i, f)
+		}
+	}
+}
+
+//wait for all the queued writes, returning the first error
+func (self *raid5File) flush() error {
+	var result error
+	for _, q := range self.queues {
+		if q == nil {
+			continue
+		}
+		if err := q.flush(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+func (self *raid5File) stopQueues() error {
+	var result error
+	for _, q := range self.queues {
+		if q == nil {
+			continue
+		}
+		if err := q.close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
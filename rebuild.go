@@ -0,0 +1,350 @@
+package raid5
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/iansmith/raid5/parity"
+)
+
+//how many objects get rebuilt between saves of the rebuild cursor to
+//the superblocks.  after a restart the rebuild picks up from the last
+//one saved.
+const REBUILD_CHECKPOINT = 64
+
+//called with the lock held
+func (self *Array) startRebuild(member int) {
+	if self.closing || self.passive {
+		return
+	}
+	self.running.Add(1)
+	go func() {
+		defer self.running.Done()
+		if err := self.Rebuild(member); err != nil {
+			log.Printf("rebuild of member %s failed: %v", memberNames[member], err)
+		}
+	}()
+}
+
+//called with the lock held by OpenArray, to take care of anything that
+//happened while the array was closed
+func (self *Array) startRebuilds() {
+	for i := range self.members {
+		switch self.members[i].state {
+		case MEMBER_FAILED:
+			self.promoteSpare(i)
+		case MEMBER_REBUILDING:
+			self.startRebuild(i)
+		}
+	}
+}
+
+//Rebuild refills member with its leg of every object, worked out from
+//the other two members (or copied from the directory it is replacing,
+//see ReplaceMember), in name order.  the rebuild cursor follows
+//along so reads can use the member for everything it has gotten past,
+//and when it's done the member is ONLINE again.  a spare that takes
+//over for a failed member gets this done to it automatically.  a
+//member that's already rebuilding carries on from the cursor.
+func (self *Array) Rebuild(member int) error {
+	if member < 0 || member >= len(self.members) {
+		return fmt.Errorf("no member %d in the array", member)
+	}
+	self.mu.Lock()
+	if self.closing {
+		self.mu.Unlock()
+		return ErrClosed
+	}
+	dir := self.dirs[member]
+	job, err := self.startJob("rebuild", member, dir)
+	if err != nil {
+		self.mu.Unlock()
+		return err
+	}
+	if self.members[member].state != MEMBER_REBUILDING {
+		self.rebuildCursor = ""
+		self.retiring = ""
+		self.setState(member, MEMBER_REBUILDING, "rebuild started")
+	}
+	resume := self.rebuildCursor
+	self.mu.Unlock()
+
+	err = self.rebuild(job, member, dir, resume)
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.finishJob(job, err)
+	if err != nil {
+		return err
+	}
+	self.rebuildCursor = ""
+	if self.retiring != "" {
+		log.Printf("%s is no longer part of the array, it has been replaced by %s", self.retiring, dir)
+		self.retiring = ""
+	}
+	self.members[member].errors = 0
+	self.setState(member, MEMBER_ONLINE, fmt.Sprintf("rebuild finished, %d of %d objects couldn't be rebuilt",
+		job.Failed, job.Total))
+	//somebody else may have been waiting for us to finish
+	for i := range self.members {
+		if self.members[i].state == MEMBER_FAILED {
+			self.promoteSpare(i)
+		}
+	}
+	return nil
+}
+
+func (self *Array) rebuild(job *Job, member int, dir string, resume string) error {
+	//the member itself doesn't count for the listing, we are past
+	//everything it has
+	names, err := self.ListFiles("")
+	if err != nil {
+		return err
+	}
+	self.mu.Lock()
+	job.Total = len(names)
+	self.mu.Unlock()
+
+	for i, name := range names {
+		var failed error
+		if resume == "" || name > resume {
+			if failed = self.rebuildObject(member, dir, name, false); failed != nil {
+				log.Printf("unable to rebuild %s onto member %s: %v", name, memberNames[member], failed)
+			}
+		}
+
+		self.mu.Lock()
+		//the member might have failed again, or been replaced, while
+		//we were at it
+		if self.dirs[member] != dir || self.members[member].state != MEMBER_REBUILDING {
+			self.mu.Unlock()
+			return fmt.Errorf("member %s (%s) is no longer rebuilding", memberNames[member], dir)
+		}
+		self.rebuildCursor = name
+		self.unsaved = true
+		job.Done++
+		if failed != nil {
+			job.Failed++
+			job.problem(fmt.Errorf("%s: %w", name, failed))
+		}
+		if (i+1)%REBUILD_CHECKPOINT == 0 {
+			self.saveSuperblocks()
+		}
+		//Close saves the cursor
+		if self.closing {
+			self.mu.Unlock()
+			return ErrClosed
+		}
+		self.mu.Unlock()
+	}
+	return nil
+}
+
+//how much of each leg is worked on at once
+const REBUILD_CHUNK = HALF_BLOCK * QUEUE_DEPTH
+
+//put back member's leg of the named object, in dir.  if the member is
+//being replaced the leg is just copied from the old directory,
+//otherwise (or if that doesn't work) it's the XOR of the legs from the
+//other two members.  since parity is d1^d2 the same thing works no
+//matter which member is missing.  unless force is set a leg that's
+//already there with the right name is left alone.
+func (self *Array) rebuildObject(member int, dir string, name string, force bool) error {
+	path, err := keyPath(name)
+	if err != nil {
+		return err
+	}
+	dirs := self.Dirs()
+	var sources []int
+	for i := range dirs {
+		if i != member && self.usable(i, name) {
+			sources = append(sources, i)
+		}
+	}
+	if len(sources) != 2 {
+		return ErrArrayFailed
+	}
+
+	//nobody writes or reads it while we are in the middle of it
+	lock, err := Locks.lock(self.lockKey, dirs, path, true)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	//where the data really is, zero length objects have no link.  the
+	//two sources have to agree on it, there's nobody to break a tie.
+	var legs [2]string
+	for i, source := range sources {
+		if legs[i], err = legName(filepath.Join(dirs[source], path)); err != nil {
+			return err
+		}
+	}
+	if legs[0] != legs[1] {
+		return fmt.Errorf("%s: %w", name, ErrNoQuorum)
+	}
+	dest, final := legs[0], ""
+	if dest != ZERO_LENGTH_LEG {
+		final = filepath.Join(filepath.Dir(path), dest)
+	}
+
+	if err := makeKeyDir(dir, path); err != nil {
+		return self.memberErrorIn(dir, member, "mkdir", err)
+	}
+	target := filepath.Join(dir, path)
+	//written since the rebuild started, so it's already right
+	if final != "" && !force {
+		if got, err := legName(target); err == nil && got == dest {
+			if _, err := os.Stat(target); err == nil {
+				self.clearMissing(name, member)
+				return nil
+			}
+		}
+	}
+	//whatever is there is left over from before the member failed, or
+	//from a rebuild that got interrupted.  a stale link takes its leg
+	//with it.
+	leftovers := []string{path, final}
+	if got, err := legName(target); err == nil && got != dest && got != ZERO_LENGTH_LEG {
+		leftovers = append(leftovers, filepath.Join(filepath.Dir(path), got))
+	}
+	for _, p := range leftovers {
+		if p == "" {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, p)); err != nil && !os.IsNotExist(err) {
+			return self.memberErrorIn(dir, member, "remove", err)
+		}
+	}
+
+	var out *os.File
+	if final == "" {
+		out, err = os.Create(target)
+		if err != nil {
+			return self.memberErrorIn(dir, member, "create", err)
+		}
+	} else {
+		out, err = os.Create(filepath.Join(dir, final))
+		if err != nil {
+			return self.memberErrorIn(dir, member, "create", err)
+		}
+		err = self.copyLeg(out, final, dirs[sources[0]])
+		if err != nil {
+			err = self.xorLegs(out, member, dir, dirs, sources, final)
+		}
+	}
+	//the rebuild cursor says this object is done once it's saved, so
+	//it had better be on the disk
+	if err == nil {
+		if err = out.Sync(); err != nil {
+			err = self.memberErrorIn(dir, member, "sync", err)
+		}
+	}
+	if closeErr := out.Close(); err == nil && closeErr != nil {
+		err = self.memberErrorIn(dir, member, "close", closeErr)
+	}
+	if err == nil && final != "" {
+		if err = os.Symlink(filepath.Join(dir, final), target); err != nil {
+			err = self.memberErrorIn(dir, member, "symlink", err)
+		}
+	}
+	if err == nil {
+		if err = syncDir(filepath.Dir(target)); err != nil {
+			err = self.memberErrorIn(dir, member, "sync directory", err)
+		}
+	}
+	if err == nil {
+		err = self.copyMeta(member, dir, dirs, sources, path)
+	}
+	if err != nil {
+		os.Remove(target)
+		if final != "" {
+			os.Remove(filepath.Join(dir, final))
+		}
+		return err
+	}
+	self.clearMissing(name, member)
+	return nil
+}
+
+//copy the leg at final from the directory being retired to out, as long
+//as it's the same size as the leg in other
+func (self *Array) copyLeg(out *os.File, final string, other string) error {
+	self.mu.Lock()
+	retiring := self.retiring
+	self.mu.Unlock()
+	if retiring == "" {
+		return os.ErrNotExist
+	}
+	in, err := os.Open(filepath.Join(retiring, final))
+	if err == nil {
+		defer in.Close()
+		var want os.FileInfo
+		if want, err = os.Stat(filepath.Join(other, final)); err == nil {
+			var n int64
+			n, err = io.Copy(out, in)
+			if err == nil && n != want.Size() {
+				err = fmt.Errorf("copied %d bytes, the leg in %s has %d: %w", n, other, want.Size(), ErrWrongSize)
+			}
+		}
+	}
+	if err != nil {
+		//start over, the other members will have to do
+		log.Printf("unable to copy %s from %s, reconstructing it: %v", final, retiring, err)
+		if _, seekErr := out.Seek(0, io.SeekStart); seekErr != nil {
+			return seekErr
+		}
+		if truncErr := out.Truncate(0); truncErr != nil {
+			return truncErr
+		}
+	}
+	return err
+}
+
+//write the XOR of the two source legs of the file at final to out
+func (self *Array) xorLegs(out *os.File, member int, dir string, dirs []string, sources []int, final string) error {
+	in := make([]*os.File, len(sources))
+	for i, src := range sources {
+		f, err := os.Open(filepath.Join(dirs[src], final))
+		if err != nil {
+			if os.IsNotExist(err) {
+				//only two legs were ever written and one is gone
+				return err
+			}
+			return self.memberErrorIn(dirs[src], src, "open", err)
+		}
+		defer f.Close()
+		in[i] = f
+	}
+
+	a := make([]byte, REBUILD_CHUNK)
+	b := make([]byte, REBUILD_CHUNK)
+	for {
+		na, errA := io.ReadFull(in[0], a)
+		nb, errB := io.ReadFull(in[1], b)
+		for i, err := range []error{errA, errB} {
+			if err != nil && err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return self.memberErrorIn(dirs[sources[i]], sources[i], "read", err)
+			}
+		}
+		if na != nb {
+			return fmt.Errorf("legs of %s are different sizes in members %s and %s: %w",
+				final, memberNames[sources[0]], memberNames[sources[1]], ErrWrongSize)
+		}
+		if na == 0 {
+			return nil
+		}
+		parity.XOR(a[:na], a[:na], b[:nb])
+		self.stats.reconstructedBytes.Add(uint64(na))
+		if _, err := out.Write(a[:na]); err != nil {
+			return self.memberErrorIn(dir, member, "write", err)
+		}
+		if errA != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package raid5
+
+import (
+	"os"
+	"path/filepath"
+)
+
+//RemoveFile deletes the named object from all the members that have
+//it.  the links go first, so the object is gone as far as anybody else
+//is concerned before its data is.
+func (self *Array) RemoveFile(name string) error {
+	path, err := keyPath(name)
+	if err != nil {
+		return err
+	}
+	dirs := self.Dirs()
+	lock, err := Locks.lock(self.lockKey, dirs, path, true)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	found := 0
+	for i, dir := range dirs {
+		if !self.writable(i) {
+			continue
+		}
+		link := filepath.Join(dir, path)
+		dest, linkErr := os.Readlink(link)
+		if err := os.Remove(link); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return self.memberErrorIn(dir, i, "remove", err)
+		}
+		found++
+		removeMeta(dir, path)
+		//zero length objects are just the one file
+		if linkErr == nil {
+			final := filepath.Join(dir, filepath.Dir(path), filepath.Base(dest))
+			if err := os.Remove(final); err != nil && !os.IsNotExist(err) {
+				return self.memberErrorIn(dir, i, "remove", err)
+			}
+		}
+		pruneKeyDir(metaRoot(dir), path)
+		changed := pruneKeyDir(dir, path)
+		if self.Durability >= DURABILITY_FSYNC_DIRS {
+			if err := syncDir(changed); err != nil {
+				return self.memberErrorIn(dir, i, "sync directory", err)
+			}
+		}
+	}
+	if found == 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	for i := range dirs {
+		self.clearMissing(name, i)
+	}
+	lock.removeFile()
+	return nil
+}
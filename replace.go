@@ -0,0 +1,65 @@
+package raid5
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+//ReplaceMember swaps dir in for member while the array keeps working,
+//for a disk that's on its way out.  every object's leg is copied to dir
+//from the old directory, or worked out from the other two members if
+//the old one can't provide it, and reads keep using the old directory
+//for anything that hasn't been copied yet.  when they're all done the
+//old directory stops being part of the array (nothing in it is
+//removed).  the copy runs in the background, see Jobs() and Wait().
+func (self *Array) ReplaceMember(member int, dir string) error {
+	if member < 0 || member >= len(self.members) {
+		return fmt.Errorf("no member %d in the array", member)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("new member %s is not a directory", dir)
+	}
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for _, d := range append(append([]string{}, self.dirs...), self.spares...) {
+		if d == dir {
+			return fmt.Errorf("%s is already part of the array", dir)
+		}
+	}
+	if sb, err := readSuperblock(dir); err == nil && sb.Array != self.id {
+		return fmt.Errorf("%s belongs to a different array", dir)
+	}
+	//the copy needs the other two to fall back on
+	for i := range self.members {
+		state := self.members[i].state
+		if i == member && state == MEMBER_REBUILDING {
+			return fmt.Errorf("member %s is already being rebuilt", memberNames[i])
+		}
+		if i != member && state != MEMBER_ONLINE && state != MEMBER_DEGRADED {
+			return fmt.Errorf("can't replace member %s while member %s is %s",
+				memberNames[member], memberNames[i], state)
+		}
+	}
+
+	old := self.dirs[member]
+	self.retiring = ""
+	//no point copying from a member we've given up on
+	if self.members[member].state != MEMBER_FAILED {
+		self.retiring = old
+	}
+	self.dirs[member] = dir
+	self.members[member] = memberHealth{state: MEMBER_REBUILDING}
+	self.rebuildCursor = ""
+	log.Printf("replacing member %s (%s) with %s", memberNames[member], old, dir)
+	if err := self.saveSuperblocks(); err != nil {
+		return err
+	}
+	self.startRebuild(member)
+	return nil
+}
@@ -0,0 +1,77 @@
+package raid5
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplaceMember(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+	fresh, err := ioutil.TempDir("", "raid5")
+	if err != nil {
+		t.Fatalf("creating new member dir: %v", err)
+	}
+	defer os.RemoveAll(fresh)
+
+	array, err := OpenArray(d1, d2, parity)
+	if err != nil {
+		t.Fatalf("failed to open new array: %v", err)
+	}
+	content := bytes.Repeat([]byte("moving day "), BLOCK_SIZE/3)
+	writeTestObject(t, array, "dir/moving", content)
+	writeTestObject(t, array, "staying", []byte("staying"))
+
+	if err := array.ReplaceMember(MEMBER_D1, d2); err == nil {
+		t.Errorf("a member can't replace another member")
+	}
+	if err := array.ReplaceMember(MEMBER_D1, fresh); err != nil {
+		t.Fatalf("failed to replace: %v", err)
+	}
+	array.Wait()
+
+	members := array.Members()
+	if members[MEMBER_D1].Dir != fresh || members[MEMBER_D1].State != MEMBER_ONLINE {
+		t.Fatalf("new directory should be d1 and online: %+v", members[MEMBER_D1])
+	}
+	//the old one is left alone
+	if _, err := os.Lstat(filepath.Join(d1, "dir", "moving")); err != nil {
+		t.Errorf("old member should still have its files: %v", err)
+	}
+	array.SetMemberState(MEMBER_D2, MEMBER_FAILED)
+	if !bytes.Equal(readTestObject(t, array, "dir/moving"), content) {
+		t.Errorf("wrong content read back from the new member")
+	}
+}
+
+func TestReadsUseRetiringMember(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+	fresh, err := ioutil.TempDir("", "raid5")
+	if err != nil {
+		t.Fatalf("creating new member dir: %v", err)
+	}
+	defer os.RemoveAll(fresh)
+
+	array := NewArray(d1, d2, parity)
+	writeTestObject(t, array, "apple", []byte("apple"))
+
+	//as if a replacement of d1 has just started
+	array.mu.Lock()
+	array.dirs[MEMBER_D1] = fresh
+	array.members[MEMBER_D1].state = MEMBER_REBUILDING
+	array.retiring = d1
+	array.mu.Unlock()
+
+	obj, err := array.OpenFile("apple")
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer obj.Close()
+	if obj.f1 == nil || obj.dirs[MEMBER_D1] != d1 {
+		t.Errorf("reads should come from the old d1 until it's copied, got %v", obj.dirs)
+	}
+}
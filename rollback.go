@@ -0,0 +1,45 @@
+package raid5
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+//undo a create that didn't make it.  whatever we managed to put in the
+//members (staging files under the starting name, renamed files, links)
+//is removed so that the name can be used again.  errors here are only
+//logged, the caller is already returning the error that got us here.
+func (self *raid5File) rollback() {
+	self.closeFiles()
+	self.creating = false
+
+	path := filepath.FromSlash(self.startingName)
+	for i, dir := range self.dirs {
+		if !self.has(i) {
+			continue
+		}
+		for _, p := range []string{path, self.finalName} {
+			if p == "" {
+				continue
+			}
+			err := os.Remove(filepath.Join(dir, p))
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("rollback of %s couldn't remove %s: %v", self.startingName, filepath.Join(dir, p), err)
+			}
+		}
+		removeMeta(dir, path)
+	}
+}
+
+//Abort gives up on a file from CreateFile without writing it, nothing
+//is left behind in the members.  for a file from OpenFile this is the
+//same as Close.
+func (self *raid5File) Abort() error {
+	if !self.creating {
+		return self.Close()
+	}
+	self.rollback()
+	self.unlock()
+	return nil
+}
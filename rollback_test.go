@@ -0,0 +1,74 @@
+package raid5
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+//every member directory should be empty (apart from directories)
+func expectNothingLeft(t *testing.T, dirs ...string) {
+	for _, dir := range dirs {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("can't read %s: %v", dir, err)
+		}
+		for _, info := range infos {
+			if !info.IsDir() {
+				t.Errorf("found %s left behind in %s", info.Name(), dir)
+			}
+		}
+	}
+}
+
+func TestRollbackOnWriteFailure(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	result, err := CreateFile(d1, d2, parity, "halfbaked")
+	if err != nil {
+		t.Fatalf("failed to create files: %v", err)
+	}
+	//the parity disk fills up, or something like it
+	result.parity.Close()
+	_, _, err = result.WriteAndClose(make([]byte, 3*BLOCK_SIZE))
+	var memberErr *MemberError
+	if !errors.As(err, &memberErr) {
+		t.Fatalf("expected a member error but got %v", err)
+	}
+	if memberErr.Member != MEMBER_PARITY {
+		t.Errorf("expected the failure to be blamed on parity, got %d", memberErr.Member)
+	}
+	expectNothingLeft(t, d1, d2, parity)
+
+	//and the name is free for another try
+	result, err = CreateFile(d1, d2, parity, "halfbaked")
+	if err != nil {
+		t.Fatalf("retry after failure didn't work: %v", err)
+	}
+	if _, _, err := result.WriteAndClose([]byte("second time lucky")); err != nil {
+		t.Fatalf("failed to write on retry: %v", err)
+	}
+}
+
+func TestAbortLeavesNothing(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	result, err := CreateFile(d1, d2, parity, "nested/nevermind")
+	if err != nil {
+		t.Fatalf("failed to create files: %v", err)
+	}
+	if err := result.Abort(); err != nil {
+		t.Fatalf("failed to abort: %v", err)
+	}
+	expectNothingLeft(t, d1, d2, parity)
+	if _, err := OpenFile(d1, d2, parity, "nested/nevermind"); !os.IsNotExist(err) {
+		t.Errorf("aborted file should not exist, got %v", err)
+	}
+	//the lock has to have been given back for this to work
+	if _, err := CreateFile(d1, d2, parity, "nested/nevermind"); err != nil {
+		t.Errorf("couldn't create after abort: %v", err)
+	}
+}
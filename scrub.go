@@ -0,0 +1,86 @@
+package raid5
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+//StartScrub verifies every object in the array in the background (see
+//Verify and Jobs).  when Verify can tell which member is at fault, the
+//bad or missing leg is rebuilt from the other two.
+func (self *Array) StartScrub() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.closing {
+		return ErrClosed
+	}
+	job, err := self.startJob("scrub", -1, "")
+	if err != nil {
+		return err
+	}
+	self.running.Add(1)
+	go func() {
+		defer self.running.Done()
+		err := self.scrub(job)
+		self.mu.Lock()
+		defer self.mu.Unlock()
+		self.finishJob(job, err)
+		log.Printf("scrub finished: %d objects, %d repaired, %d with problems that couldn't be fixed",
+			job.Done, job.Repaired, job.Failed)
+	}()
+	return nil
+}
+
+func (self *Array) scrub(job *Job) error {
+	names, err := self.ListFiles("")
+	if err != nil {
+		return err
+	}
+	self.mu.Lock()
+	job.Total = len(names)
+	self.mu.Unlock()
+
+	for _, name := range names {
+		repaired, err := self.scrubObject(name)
+		if err != nil {
+			log.Printf("scrub: %v", err)
+		}
+		self.mu.Lock()
+		job.Done++
+		if repaired {
+			job.Repaired++
+		}
+		if err != nil {
+			job.Failed++
+			job.problem(err)
+		}
+		closing := self.closing
+		self.mu.Unlock()
+		if closing {
+			return ErrClosed
+		}
+	}
+	return nil
+}
+
+//verify one object and fix it if we can, the error is whatever is
+//still wrong with it
+func (self *Array) scrubObject(name string) (bool, error) {
+	err := self.Verify(name)
+	var verr *VerifyError
+	if !errors.As(err, &verr) || verr.Member < 0 {
+		return false, err
+	}
+	//a member that isn't being written can't be fixed, and one that's
+	//rebuilding will get to it
+	if !self.usable(verr.Member, name) || !self.writable(verr.Member) {
+		return false, err
+	}
+	log.Printf("scrub: %v, rebuilding it", err)
+	dir := self.Dirs()[verr.Member]
+	if fixErr := self.rebuildObject(verr.Member, dir, name, true); fixErr != nil {
+		return false, fmt.Errorf("%w, and couldn't rebuild it: %v", err, fixErr)
+	}
+	return true, self.Verify(name)
+}
@@ -0,0 +1,37 @@
+package raid5
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScrubRepairs(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	content := bytes.Repeat([]byte("scrub a dub "), BLOCK_SIZE/6)
+	writeTestObject(t, array, "fine", content)
+	writeTestObject(t, array, "rotten", content)
+	writeTestObject(t, array, "lopsided", content)
+	corruptLeg(t, array, "rotten", MEMBER_D2)
+	if err := os.Remove(filepath.Join(parity, "lopsided")); err != nil {
+		t.Fatalf("failed to remove link: %v", err)
+	}
+
+	if err := array.StartScrub(); err != nil {
+		t.Fatalf("failed to start scrub: %v", err)
+	}
+	array.Wait()
+	jobs := array.Jobs()
+	if len(jobs) != 1 || jobs[0].Kind != "scrub" || jobs[0].Done != 3 || jobs[0].Repaired != 2 || jobs[0].Failed != 0 {
+		t.Fatalf("wrong scrub job: %+v", jobs)
+	}
+	for _, name := range []string{"fine", "rotten", "lopsided"} {
+		if err := array.Verify(name); err != nil {
+			t.Errorf("%s wasn't fixed: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package raid5
+
+import (
+	"errors"
+)
+
+func diskSpace(dir string) (uint64, uint64, error) {
+	return 0, 0, errors.New("free space isn't known on this system")
+}
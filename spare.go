@@ -0,0 +1,110 @@
+package raid5
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+//AddSpare gives the array a directory that it can use in place of a
+//member that fails.  the spare takes over, and gets rebuilt from the
+//other two members, as soon as a member is marked failed, including
+//one that already is.  arrays from OpenArray remember their spares.
+func (self *Array) AddSpare(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("spare %s is not a directory", dir)
+	}
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for _, d := range append(append([]string{}, self.dirs...), self.spares...) {
+		if d == dir {
+			return fmt.Errorf("%s is already part of the array", dir)
+		}
+	}
+	if sb, err := readSuperblock(dir); err == nil && sb.Array != self.id {
+		return fmt.Errorf("%s belongs to a different array", dir)
+	}
+	self.spares = append(self.spares, dir)
+	if err := self.saveSuperblocks(); err != nil {
+		return err
+	}
+	for i := range self.members {
+		if self.members[i].state == MEMBER_FAILED {
+			self.promoteSpare(i)
+		}
+	}
+	return nil
+}
+
+//RemoveSpare takes back a directory given to AddSpare that hasn't been
+//used yet.
+func (self *Array) RemoveSpare(dir string) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for i, spare := range self.spares {
+		if spare == dir {
+			self.spares = append(self.spares[:i:i], self.spares[i+1:]...)
+			return self.saveSuperblocks()
+		}
+	}
+	return fmt.Errorf("%s is not a spare", dir)
+}
+
+//Spares returns the spare directories that haven't been used yet.
+func (self *Array) Spares() []string {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return append([]string{}, self.spares...)
+}
+
+//called with the lock held when member has failed.  if there is a
+//spare it becomes the member and a rebuild onto it is started.
+func (self *Array) promoteSpare(member int) {
+	if len(self.spares) == 0 || self.passive {
+		return
+	}
+	//there is only one rebuild cursor, and with another member not
+	//all there yet there isn't enough left to rebuild from anyway
+	for i := range self.members {
+		if i != member && self.members[i].state != MEMBER_ONLINE && self.members[i].state != MEMBER_DEGRADED {
+			log.Printf("not enough good members to rebuild member %s onto a spare", memberNames[member])
+			return
+		}
+	}
+	spare := self.spares[0]
+	self.spares = self.spares[1:]
+	old := self.dirs[member]
+	self.dirs[member] = spare
+	self.members[member] = memberHealth{state: MEMBER_REBUILDING}
+	self.rebuildCursor = ""
+	log.Printf("spare %s is taking over member %s from %s", spare, memberNames[member], old)
+	self.saveSuperblocks()
+	self.startRebuild(member)
+}
+
+//CheckMembers looks for members that have disappeared out from under
+//an array from OpenArray, like a disk that got unmounted, and marks
+//them failed.  it's cheap enough to call every so often.
+func (self *Array) CheckMembers() {
+	if !self.persistent {
+		return
+	}
+	for i, dir := range self.Dirs() {
+		if !self.writable(i) {
+			continue
+		}
+		if _, err := readSuperblock(dir); err != nil {
+			self.mu.Lock()
+			//it might have been replaced while we were looking
+			if self.dirs[i] == dir && self.members[i].state != MEMBER_FAILED {
+				self.setState(i, MEMBER_FAILED, fmt.Sprintf("unable to read superblock: %v", err))
+			}
+			self.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package raid5
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSpareTakesOver(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+	spare, err := ioutil.TempDir("", "raid5")
+	if err != nil {
+		t.Fatalf("creating spare dir: %v", err)
+	}
+	defer os.RemoveAll(spare)
+
+	array, err := OpenArray(d1, d2, parity)
+	if err != nil {
+		t.Fatalf("failed to open new array: %v", err)
+	}
+	if err := array.AddSpare(spare); err != nil {
+		t.Fatalf("failed to add spare: %v", err)
+	}
+	if err := array.AddSpare(d1); err == nil {
+		t.Errorf("a member can't be its own spare")
+	}
+
+	objects := map[string][]byte{
+		"big":          bytes.Repeat([]byte("spares are good "), BLOCK_SIZE/5),
+		"nested/small": []byte("small"),
+		"empty":        []byte{},
+	}
+	for name, content := range objects {
+		writeTestObject(t, array, name, content)
+	}
+
+	//the disk goes away, and gets noticed
+	breakMember(t, d2)
+	array.CheckMembers()
+	array.Wait()
+
+	members := array.Members()
+	if members[MEMBER_D2].Dir != spare || members[MEMBER_D2].State != MEMBER_ONLINE {
+		t.Fatalf("spare should have taken over d2 and been rebuilt: %+v", members[MEMBER_D2])
+	}
+	if len(array.Spares()) != 0 {
+		t.Errorf("spare should have been used up: %v", array.Spares())
+	}
+	jobs := array.Jobs()
+	if len(jobs) != 1 || jobs[0].Running() || jobs[0].Done != len(objects) || jobs[0].Failed != 0 {
+		t.Errorf("wrong rebuild job: %+v", jobs)
+	}
+
+	//the rebuilt member has to be right, so take away d1 and read
+	array.SetMemberState(MEMBER_D1, MEMBER_FAILED)
+	for name, content := range objects {
+		if !bytes.Equal(readTestObject(t, array, name), content) {
+			t.Errorf("wrong content for %s read from the spare", name)
+		}
+	}
+
+	//the array knows where d2 is now
+	again, err := OpenArray(d1, d2, parity)
+	if err != nil {
+		t.Fatalf("failed to reopen array: %v", err)
+	}
+	if dir := again.Dirs()[MEMBER_D2]; dir != spare {
+		t.Errorf("reopened array should use the spare for d2, got %s", dir)
+	}
+}
+
+func TestRebuildFixesDegraded(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	content := bytes.Repeat([]byte("put it back "), BLOCK_SIZE/6)
+	breakMember(t, parity)
+	writeTestObject(t, array, "partial", content)
+	fixMember(t, parity)
+
+	if err := array.Rebuild(MEMBER_PARITY); err != nil {
+		t.Fatalf("failed to rebuild: %v", err)
+	}
+	if missing, _ := array.Degraded(MEMBER_PARITY); len(missing) != 0 {
+		t.Errorf("rebuild should have cleared the missing records: %v", missing)
+	}
+	array.SetMemberState(MEMBER_D2, MEMBER_FAILED)
+	if !bytes.Equal(readTestObject(t, array, "partial"), content) {
+		t.Errorf("wrong content read back with rebuilt parity")
+	}
+}
+
+func TestRebuildWrongSizeLegs(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	writeTestObject(t, array, "lopsided", bytes.Repeat([]byte("uneven "), BLOCK_SIZE/3))
+	leg, err := legName(filepath.Join(parity, "lopsided"))
+	if err != nil {
+		t.Fatalf("no leg: %v", err)
+	}
+	if err := os.Truncate(filepath.Join(parity, leg), HALF_BLOCK); err != nil {
+		t.Fatalf("failed to truncate: %v", err)
+	}
+	if err := array.rebuildObject(MEMBER_D1, d1, "lopsided", true); !errors.Is(err, ErrWrongSize) {
+		t.Errorf("expected ErrWrongSize, got %v", err)
+	}
+}
+
+func TestCloseStopsRebuild(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array, err := OpenArray(d1, d2, parity)
+	if err != nil {
+		t.Fatalf("failed to open new array: %v", err)
+	}
+	writeTestObject(t, array, "a", []byte("a"))
+	writeTestObject(t, array, "b", []byte("b"))
+	if err := array.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if err := array.Rebuild(MEMBER_D1); err != ErrClosed {
+		t.Errorf("expected a closed array not to rebuild, got %v", err)
+	}
+
+	//a rebuild that was going when the array closed starts up again
+	array, err = OpenArray(d1, d2, parity)
+	if err != nil {
+		t.Fatalf("failed to reopen array: %v", err)
+	}
+	array.mu.Lock()
+	array.members[MEMBER_D1].state = MEMBER_REBUILDING
+	array.rebuildCursor = "a"
+	array.saveSuperblocks()
+	array.mu.Unlock()
+	array.Close()
+
+	array, err = OpenArray(d1, d2, parity)
+	if err != nil {
+		t.Fatalf("failed to reopen array: %v", err)
+	}
+	array.Wait()
+	jobs := array.Jobs()
+	if len(jobs) != 1 || jobs[0].Total != 2 || jobs[0].Done != 2 {
+		t.Errorf("expected a rebuild that picked up from the cursor: %+v", jobs)
+	}
+	if state := array.Members()[MEMBER_D1].State; state != MEMBER_ONLINE {
+		t.Errorf("rebuilt member should be online, is %v", state)
+	}
+}
+
+func TestReadOnlyLeavesSpareAlone(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+	spare, err := ioutil.TempDir("", "raid5")
+	if err != nil {
+		t.Fatalf("creating spare dir: %v", err)
+	}
+	defer os.RemoveAll(spare)
+
+	array, err := OpenArray(d1, d2, parity)
+	if err != nil {
+		t.Fatalf("failed to open new array: %v", err)
+	}
+	if err := array.AddSpare(spare); err != nil {
+		t.Fatalf("failed to add spare: %v", err)
+	}
+	content := []byte("only looking")
+	writeTestObject(t, array, "obj", content)
+	if err := array.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if err := os.Remove(superblockPath(d2)); err != nil {
+		t.Fatalf("failed to remove superblock: %v", err)
+	}
+	before, err := ioutil.ReadFile(superblockPath(d1))
+	if err != nil {
+		t.Fatalf("failed to read superblock: %v", err)
+	}
+
+	array, err = OpenArrayReadOnly(d1, d2, parity)
+	if err != nil {
+		t.Fatalf("failed to open read only: %v", err)
+	}
+	if state := array.Members()[MEMBER_D2].State; state != MEMBER_FAILED {
+		t.Errorf("d2 has no superblock, it should be failed, got %v", state)
+	}
+	if spares := array.Spares(); len(spares) != 1 || spares[0] != spare {
+		t.Errorf("the spare shouldn't have been used: %v", spares)
+	}
+	if !bytes.Equal(readTestObject(t, array, "obj"), content) {
+		t.Errorf("wrong content read")
+	}
+	if err := array.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if len(array.Jobs()) != 0 {
+		t.Errorf("nothing should have been started: %+v", array.Jobs())
+	}
+	after, err := ioutil.ReadFile(superblockPath(d1))
+	if err != nil || !bytes.Equal(before, after) {
+		t.Errorf("superblock was rewritten: %v", err)
+	}
+	if _, err := os.Stat(superblockPath(d2)); !os.IsNotExist(err) {
+		t.Errorf("d2 shouldn't have gotten a superblock: %v", err)
+	}
+}
+
+func TestSharedWritesWithoutTakingOver(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+	spare, err := ioutil.TempDir("", "raid5")
+	if err != nil {
+		t.Fatalf("creating spare dir: %v", err)
+	}
+	defer os.RemoveAll(spare)
+
+	array, err := OpenArray(d1, d2, parity)
+	if err != nil {
+		t.Fatalf("failed to open new array: %v", err)
+	}
+	if err := array.AddSpare(spare); err != nil {
+		t.Fatalf("failed to add spare: %v", err)
+	}
+	if err := array.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	superblocks := func() [][]byte {
+		result := [][]byte{}
+		for _, dir := range []string{d1, parity} {
+			raw, err := ioutil.ReadFile(superblockPath(dir))
+			if err != nil {
+				t.Fatalf("failed to read superblock: %v", err)
+			}
+			result = append(result, raw)
+		}
+		return result
+	}
+
+	//nothing changes, nothing is written
+	before := superblocks()
+	array, err = OpenArrayShared(d1, d2, parity)
+	if err != nil {
+		t.Fatalf("failed to open shared: %v", err)
+	}
+	writeTestObject(t, array, "obj", []byte("shared"))
+	if err := array.RemoveFile("obj"); err != nil {
+		t.Fatalf("failed to remove: %v", err)
+	}
+	if err := array.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if after := superblocks(); !reflect.DeepEqual(before, after) {
+		t.Errorf("superblocks were rewritten")
+	}
+
+	//a member without its superblock is somebody else's business
+	if err := os.Remove(superblockPath(d2)); err != nil {
+		t.Fatalf("failed to remove superblock: %v", err)
+	}
+	array, err = OpenArrayShared(d1, d2, parity)
+	if err != nil {
+		t.Fatalf("failed to open shared: %v", err)
+	}
+	if state := array.Members()[MEMBER_D2].State; state != MEMBER_FAILED {
+		t.Errorf("d2 has no superblock, it should be failed, got %v", state)
+	}
+	writeTestObject(t, array, "obj", []byte("shared"))
+	if err := array.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if spares := array.Spares(); len(spares) != 1 || spares[0] != spare {
+		t.Errorf("the spare shouldn't have been used: %v", spares)
+	}
+	if len(array.Jobs()) != 0 {
+		t.Errorf("nothing should have been started: %+v", array.Jobs())
+	}
+	if after := superblocks(); !reflect.DeepEqual(before, after) {
+		t.Errorf("superblocks were rewritten")
+	}
+
+	//but a member failing while it's open is saved
+	array, err = OpenArrayShared(d1, d2, parity)
+	if err != nil {
+		t.Fatalf("failed to open shared: %v", err)
+	}
+	if err := array.SetMemberState(MEMBER_PARITY, MEMBER_FAILED); err != nil {
+		t.Fatalf("failed to fail parity: %v", err)
+	}
+	if err := array.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	sb, err := readSuperblock(d1)
+	if err != nil {
+		t.Fatalf("failed to read superblock: %v", err)
+	}
+	if sb.States[MEMBER_PARITY] != MEMBER_FAILED {
+		t.Errorf("parity failing wasn't saved: %v", sb.States)
+	}
+	if spares := array.Spares(); len(spares) != 1 {
+		t.Errorf("the spare shouldn't have been used: %v", spares)
+	}
+}
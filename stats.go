@@ -0,0 +1,66 @@
+package raid5
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+//Stats counts things that have happened to the array since it was
+//created, for monitoring.
+type Stats struct {
+	//reads that had to use parity because a data member couldn't be
+	//used
+	DegradedReads uint64
+	//bytes worked out from parity, by reads and by rebuilds
+	ReconstructedBytes uint64
+	//reads where the data didn't match the hash it was written with
+	ChecksumFailures uint64
+	//I/O errors on each member.  unlike MemberStatus.Errors these
+	//never go back to zero.
+	MemberErrors [3]uint64
+	//objects opened where a member's leg disagreed with the other two,
+	//by member
+	StaleLegs [3]uint64
+	//stripes where a data leg was too slow (see Array.HedgeAfter) and
+	//was worked out from parity instead
+	HedgedReads uint64
+	//reads from each member and the time they took, not counting the
+	//time spent waiting in line for the disk
+	MemberReads    [3]uint64
+	MemberReadTime [3]time.Duration
+}
+
+//the live version of Stats, kept in the Array
+type arrayStats struct {
+	degradedReads      atomic.Uint64
+	reconstructedBytes atomic.Uint64
+	checksumFailures   atomic.Uint64
+	memberErrors       [3]atomic.Uint64
+	staleLegs          [3]atomic.Uint64
+	hedgedReads        atomic.Uint64
+	memberReads        [3]atomic.Uint64
+	memberReadNanos    [3]atomic.Uint64
+}
+
+//Stats returns a copy of the array's counters.
+func (self *Array) Stats() Stats {
+	result := Stats{
+		DegradedReads:      self.stats.degradedReads.Load(),
+		ReconstructedBytes: self.stats.reconstructedBytes.Load(),
+		ChecksumFailures:   self.stats.checksumFailures.Load(),
+		HedgedReads:        self.stats.hedgedReads.Load(),
+	}
+	for i := range result.MemberErrors {
+		result.MemberErrors[i] = self.stats.memberErrors[i].Load()
+		result.StaleLegs[i] = self.stats.staleLegs[i].Load()
+		result.MemberReads[i] = self.stats.memberReads[i].Load()
+		result.MemberReadTime[i] = time.Duration(self.stats.memberReadNanos[i].Load())
+	}
+	return result
+}
+
+//called by the member queues after every read
+func (self *arrayStats) readTook(member int, d time.Duration) {
+	self.memberReads[member].Add(1)
+	self.memberReadNanos[member].Add(uint64(d))
+}
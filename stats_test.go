@@ -0,0 +1,71 @@
+package raid5
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStatsCountDegradedReads(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	content := bytes.Repeat([]byte("count me "), BLOCK_SIZE/4)
+	writeTestObject(t, array, "counted", content)
+	readTestObject(t, array, "counted")
+	if stats := array.Stats(); stats.DegradedReads != 0 || stats.ReconstructedBytes != 0 {
+		t.Errorf("nothing should have been degraded yet: %+v", stats)
+	}
+
+	if err := os.Remove(filepath.Join(d2, "counted")); err != nil {
+		t.Fatalf("failed to remove link: %v", err)
+	}
+	if !bytes.Equal(readTestObject(t, array, "counted"), content) {
+		t.Errorf("wrong content read back without d2")
+	}
+	stats := array.Stats()
+	if stats.DegradedReads != 1 {
+		t.Errorf("expected one degraded read, got %d", stats.DegradedReads)
+	}
+	//the object is a little over two blocks long
+	if stats.ReconstructedBytes != 3*HALF_BLOCK {
+		t.Errorf("expected %d reconstructed bytes, got %d", 3*HALF_BLOCK, stats.ReconstructedBytes)
+	}
+}
+
+func TestChecksumFailure(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	writeTestObject(t, array, "rotten", []byte("perfectly good data"))
+	obj, err := array.OpenFile("rotten")
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	obj.Close()
+	//flip a bit behind the library's back
+	leg := filepath.Join(d1, obj.finalName)
+	f, err := os.OpenFile(leg, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("failed to open leg: %v", err)
+	}
+	f.WriteAt([]byte("P"), 0)
+	f.Close()
+
+	obj, err = array.OpenFile("rotten")
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer obj.Close()
+	out := make([]byte, obj.Size())
+	if _, err := obj.ReadFile(out, 0); !errors.Is(err, ErrChecksum) {
+		t.Errorf("expected a checksum error, got %v", err)
+	}
+	if n := array.Stats().ChecksumFailures; n != 1 {
+		t.Errorf("expected one checksum failure, got %d", n)
+	}
+}
@@ -0,0 +1,347 @@
+package raid5
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//every member of an array from OpenArray has this directory in it for
+//the library's own files.  object names can't start with it.
+const RESERVED_DIR = ".raid5"
+
+const SUPERBLOCK_VERSION = 1
+
+//superblock is written into every member so the array can remember
+//which directory is which member and what state they are all in.  the
+//copy with the highest generation is the one that's right.
+type superblock struct {
+	Version       int
+	Array         string
+	Member        int
+	Generation    uint64
+	States        []MemberState
+	RebuildCursor string
+	//where the members and spares are, which can change when a spare
+	//takes over for a failed member
+	Dirs   []string
+	Spares []string
+	//the directory a member is replacing, until the copy is done
+	Retiring string
+	//BLOCK_SIZE when the array was made, zero in old superblocks
+	BlockSize int
+}
+
+func superblockPath(dir string) string {
+	return filepath.Join(dir, RESERVED_DIR, "superblock")
+}
+
+func readSuperblock(dir string) (*superblock, error) {
+	raw, err := ioutil.ReadFile(superblockPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	result := &superblock{}
+	if err := json.Unmarshal(raw, result); err != nil {
+		return nil, fmt.Errorf("superblock in %s: %v", dir, err)
+	}
+	if result.Version != SUPERBLOCK_VERSION {
+		return nil, fmt.Errorf("superblock in %s: unknown version %d", dir, result.Version)
+	}
+	//everything else indexes by member, so a superblock that's been
+	//mangled (or edited by hand) has to be caught here
+	if result.Member < 0 || result.Member >= len(memberNames) {
+		return nil, fmt.Errorf("superblock in %s: no member %d in an array", dir, result.Member)
+	}
+	if len(result.States) != len(memberNames) {
+		return nil, fmt.Errorf("superblock in %s: %d member states, expected %d", dir, len(result.States), len(memberNames))
+	}
+	return result, nil
+}
+
+//write to a temp file and rename it over the old one, so a crash
+//leaves either the old superblock or the new one
+func writeSuperblock(dir string, sb *superblock) error {
+	//not MkdirAll: if the member directory itself is gone (unmounted,
+	//say) we don't want to make a new one on some other disk
+	if err := os.Mkdir(filepath.Join(dir, RESERVED_DIR), 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	raw, err := json.MarshalIndent(sb, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := superblockPath(dir)
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(raw)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+//called with the lock held, after any change to the state of the
+//array.  arrays from NewArray only keep their state in memory.
+func (self *Array) saveSuperblocks() error {
+	if !self.persistent {
+		return nil
+	}
+	self.generation++
+	var result error
+	written := 0
+	for i, dir := range self.dirs {
+		//a failed member may not even be there, we don't care what
+		//it thinks any more
+		if self.members[i].state == MEMBER_FAILED {
+			continue
+		}
+		if err := writeSuperblock(dir, self.superblockFor(i)); err != nil {
+			log.Printf("unable to write superblock for member %s (%s): %v", memberNames[i], dir, err)
+			result = err
+			continue
+		}
+		written++
+	}
+	//as long as two members have it, the next OpenArray will see it
+	if written >= 2 {
+		self.unsaved = false
+		return nil
+	}
+	self.unsaved = true
+	return result
+}
+
+func (self *Array) superblockFor(member int) *superblock {
+	states := make([]MemberState, len(self.members))
+	for i, h := range self.members {
+		states[i] = h.state
+	}
+	return &superblock{
+		Version:       SUPERBLOCK_VERSION,
+		Array:         self.id,
+		Member:        member,
+		Generation:    self.generation,
+		States:        states,
+		RebuildCursor: self.rebuildCursor,
+		Dirs:          append([]string{}, self.dirs...),
+		Spares:        append([]string{}, self.spares...),
+		Retiring:      self.retiring,
+		BlockSize:     BLOCK_SIZE,
+	}
+}
+
+func newArrayID() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", raw), nil
+}
+
+//OpenArray is like NewArray, but the state of the members is kept in a
+//superblock in each member directory so it survives restarts.  the
+//first time it's used on a set of directories it sets them up as a new
+//array.  a member that has lost its superblock since then (a disk that
+//didn't get mounted, for instance) is marked failed, and replaced by a
+//spare if there is one.  rebuilds that were interrupted start again.
+func OpenArray(d1, d2, parity string) (*Array, error) {
+	return openArray(d1, d2, parity, true, false)
+}
+
+//OpenArrayReadOnly is OpenArray for programs that only look at an array
+//that something else may have open, like a server.  the superblocks are
+//read but never written, and nothing is started: a member without a
+//superblock is only failed in memory, spares are left alone and
+//rebuilds aren't picked up again.  objects can still be written, it's
+//up to the caller not to.
+func OpenArrayReadOnly(d1, d2, parity string) (*Array, error) {
+	return openArray(d1, d2, parity, false, true)
+}
+
+//OpenArrayShared is OpenArray for programs that write objects into an
+//array something else may have open, like a server.  like
+//OpenArrayReadOnly nothing is started and what's found opening it is
+//only fixed in memory, but a member that fails while the objects are
+//being written is saved to the superblocks.  they aren't written
+//otherwise, not even by Close.
+func OpenArrayShared(d1, d2, parity string) (*Array, error) {
+	return openArray(d1, d2, parity, true, true)
+}
+
+//persistent arrays write their superblocks, passive ones leave spares
+//and rebuilds to whoever has the array open for real
+func openArray(d1, d2, parity string, persistent, passive bool) (*Array, error) {
+	result := NewArray(d1, d2, parity)
+	result.persistent = persistent
+	result.passive = passive
+
+	dirs, found, best, err := findSuperblocks(result.dirs)
+	if err != nil {
+		return nil, err
+	}
+	result.dirs = dirs
+	if best != nil && best.BlockSize != 0 && best.BlockSize != BLOCK_SIZE {
+		return nil, fmt.Errorf("array was made with a block size of %d, this is %d", best.BlockSize, BLOCK_SIZE)
+	}
+
+	result.mu.Lock()
+	defer result.mu.Unlock()
+
+	//brand new array
+	if best == nil {
+		if !persistent {
+			return result, nil
+		}
+		id, err := newArrayID()
+		if err != nil {
+			return nil, err
+		}
+		result.id = id
+		if err := result.saveSuperblocks(); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	result.id = best.Array
+	result.generation = best.Generation
+	result.rebuildCursor = best.RebuildCursor
+	result.spares = append([]string{}, best.Spares...)
+	result.retiring = best.Retiring
+	for i, state := range best.States {
+		result.members[i].state = state
+	}
+	changed := false
+	for i, sb := range found {
+		if sb == nil && result.members[i].state != MEMBER_FAILED {
+			old := result.members[i].state
+			result.members[i].state = MEMBER_FAILED
+			result.logState(i, old, "superblock is missing")
+			changed = true
+		}
+		//somebody missed the last change
+		if sb != nil && sb.Generation != result.generation {
+			changed = true
+		}
+	}
+	if changed && !passive {
+		if err := result.saveSuperblocks(); err != nil {
+			return nil, err
+		}
+	}
+	result.startRebuilds()
+	return result, nil
+}
+
+//readSuperblocks, but if the array says a spare has taken over for
+//somebody since the caller's list of directories was made, go with
+//what the array says.  returns the directories to use.
+func findSuperblocks(dirs []string) ([]string, []*superblock, *superblock, error) {
+	found, best, err := readSuperblocks(dirs)
+	if err != nil || best == nil || len(best.Dirs) != len(dirs) {
+		return dirs, found, best, err
+	}
+	moved := false
+	for i, dir := range best.Dirs {
+		if dir != dirs[i] {
+			log.Printf("member %s has moved from %s to %s", memberNames[i], dirs[i], dir)
+			moved = true
+		}
+	}
+	if !moved {
+		return dirs, found, best, nil
+	}
+	dirs = append([]string{}, best.Dirs...)
+	found, best, err = readSuperblocks(dirs)
+	return dirs, found, best, err
+}
+
+//read the superblock of each member in dirs, returning them all (nil
+//for the ones that couldn't be read) and the newest of them
+func readSuperblocks(dirs []string) ([]*superblock, *superblock, error) {
+	var best *superblock
+	found := make([]*superblock, len(dirs))
+	for i, dir := range dirs {
+		sb, err := readSuperblock(dir)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("unable to read superblock for member %s (%s): %v", memberNames[i], dir, err)
+			}
+			continue
+		}
+		if sb.Member != i {
+			return nil, nil, fmt.Errorf("%s is member %s of its array, not %s",
+				dir, memberNames[sb.Member], memberNames[i])
+		}
+		if best != nil && sb.Array != best.Array {
+			return nil, nil, fmt.Errorf("%s belongs to a different array than %s", dir, dirs[best.Member])
+		}
+		found[i] = sb
+		if best == nil || sb.Generation > best.Generation {
+			best = sb
+		}
+	}
+	return found, best, nil
+}
+
+//ID is the unique id of the array, empty for arrays from NewArray.
+func (self *Array) ID() string {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.id
+}
+
+//CheckArray looks over the member directories of an array without
+//changing anything, for programs that would rather not start at all
+//than have OpenArray quietly fail a member.  every directory has to be
+//there, and if any member has a superblock then all of them have to,
+//except for the ones the array already knows have failed
+//(ErrHalfInitialized).  no superblocks at all is fine, that's a new
+//array.
+func CheckArray(d1, d2, parity string) error {
+	dirs := []string{d1, d2, parity}
+	for i, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("member %s: %w", memberNames[i], err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("member %s: %s is not a directory", memberNames[i], dir)
+		}
+	}
+	dirs, found, best, err := findSuperblocks(dirs)
+	if err != nil || best == nil {
+		return err
+	}
+	if best.BlockSize != 0 && best.BlockSize != BLOCK_SIZE {
+		return fmt.Errorf("array was made with a block size of %d, this is %d", best.BlockSize, BLOCK_SIZE)
+	}
+	missing := []string{}
+	for i, sb := range found {
+		if sb == nil && (i >= len(best.States) || best.States[i] != MEMBER_FAILED) {
+			missing = append(missing, fmt.Sprintf("%s (%s)", memberNames[i], dirs[i]))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: no superblock in %s", ErrHalfInitialized, strings.Join(missing, ", "))
+	}
+	return nil
+}
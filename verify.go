@@ -0,0 +1,151 @@
+package raid5
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"hash"
+	"os"
+
+	"github.com/iansmith/raid5/parity"
+)
+
+//VerifyError is what Verify found wrong with an object.  Err is
+//ErrChecksum if something doesn't match and ErrDegraded if a member is
+//missing its leg but everything else is right.
+type VerifyError struct {
+	Name string
+	//the member at fault, -1 if there's no telling which it is
+	Member int
+	Reason string
+	Err    error
+}
+
+func (self *VerifyError) Error() string {
+	if self.Member < 0 {
+		return fmt.Sprintf("%s: %s", self.Name, self.Reason)
+	}
+	return fmt.Sprintf("%s: %s on member %s", self.Name, self.Reason, memberNames[self.Member])
+}
+
+func (self *VerifyError) Unwrap() error {
+	return self.Err
+}
+
+//Verify reads every leg of the named object and checks that it all
+//hangs together: the parity has to match the data, and the data has to
+//match the hash it was written with.  when something is wrong it tries
+//to work out which member is to blame, see VerifyError.
+func (self *Array) Verify(name string) error {
+	obj, err := self.OpenFile(name)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	return obj.verify()
+}
+
+func (self *raid5File) verify() error {
+	files := []*os.File{self.f1, self.f2, self.parity}
+	bad := func(member int, reason string, err error) error {
+		return &VerifyError{Name: self.startingName, Member: member, Reason: reason, Err: err}
+	}
+
+	//every leg has to be the same, whole number of stripes
+	stripes := (self.expectedLen + BLOCK_SIZE - 1) / BLOCK_SIZE
+	for i, f := range files {
+		if f == nil {
+			continue
+		}
+		info, err := f.Stat()
+		if err != nil {
+			return self.memberError(i, "stat", err)
+		}
+		if info.Size() != stripes*HALF_BLOCK {
+			return bad(i, fmt.Sprintf("leg is %d bytes, expected %d", info.Size(), stripes*HALF_BLOCK), ErrChecksum)
+		}
+	}
+
+	//with all three legs there are three ways to get the data: the two
+	//data legs, or either one of them with parity.  if the first one
+	//doesn't match the hash, the others say who is wrong.
+	missing := self.Missing()
+	sums := []hash.Hash{md5.New(), md5.New(), md5.New()}
+	parityOK := true
+	legs := [][]byte{make([]byte, HALF_BLOCK), make([]byte, HALF_BLOCK), make([]byte, HALF_BLOCK)}
+	other := make([]byte, HALF_BLOCK)
+	remaining := self.expectedLen
+	feed := func(h hash.Hash, a, b []byte) {
+		n := remaining
+		if n > HALF_BLOCK {
+			n = HALF_BLOCK
+		}
+		h.Write(a[:n])
+		if remaining > HALF_BLOCK {
+			n = remaining - HALF_BLOCK
+			if n > HALF_BLOCK {
+				n = HALF_BLOCK
+			}
+			h.Write(b[:n])
+		}
+	}
+	for s := int64(0); s < stripes; s++ {
+		for i, f := range files {
+			if f == nil {
+				continue
+			}
+			n, err := f.ReadAt(legs[i], s*HALF_BLOCK)
+			if err == nil && n != HALF_BLOCK {
+				err = fmt.Errorf("read %d of %d bytes of stripe %d: %w", n, HALF_BLOCK, s, ErrShortRead)
+			}
+			if err != nil {
+				return self.memberError(i, "read", err)
+			}
+		}
+		d1, d2, p := legs[MEMBER_D1], legs[MEMBER_D2], legs[MEMBER_PARITY]
+		switch {
+		case len(missing) == 0:
+			feed(sums[0], d1, d2)
+			//d2 worked out from d1
+			parity.XOR(other, d1, p)
+			feed(sums[1], d1, other)
+			if parityOK && !bytes.Equal(other, d2) {
+				parityOK = false
+			}
+			//d1 worked out from d2
+			parity.XOR(other, d2, p)
+			feed(sums[2], other, d2)
+		case missing[0] == MEMBER_D1:
+			parity.XOR(other, d2, p)
+			feed(sums[0], other, d2)
+		case missing[0] == MEMBER_D2:
+			parity.XOR(other, d1, p)
+			feed(sums[0], d1, other)
+		default:
+			feed(sums[0], d1, d2)
+		}
+		remaining -= BLOCK_SIZE
+	}
+
+	matches := func(h hash.Hash) bool {
+		//empty objects don't have a hash to check
+		return self.expectedHash == nil || bytes.Equal(h.Sum(nil), self.expectedHash)
+	}
+	if len(missing) > 0 {
+		if !matches(sums[0]) {
+			return bad(-1, "data doesn't match its hash", ErrChecksum)
+		}
+		return bad(missing[0], "leg is missing", ErrDegraded)
+	}
+	switch {
+	case matches(sums[0]) && parityOK:
+		return nil
+	case matches(sums[0]):
+		return bad(MEMBER_PARITY, "parity doesn't match the data", ErrChecksum)
+	case matches(sums[1]):
+		return bad(MEMBER_D2, "data doesn't match its hash", ErrChecksum)
+	case matches(sums[2]):
+		return bad(MEMBER_D1, "data doesn't match its hash", ErrChecksum)
+	}
+	return bad(-1, "data doesn't match its hash", ErrChecksum)
+}
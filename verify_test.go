@@ -0,0 +1,132 @@
+package raid5
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//flip the first byte of member's leg of the named object
+func corruptLeg(t *testing.T, array *Array, name string, member int) {
+	obj, err := array.OpenFile(name)
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	obj.Close()
+	f, err := os.OpenFile(filepath.Join(array.Dirs()[member], obj.finalName), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("failed to open leg: %v", err)
+	}
+	defer f.Close()
+	b := make([]byte, 1)
+	f.ReadAt(b, 0)
+	b[0] ^= 0xff
+	f.WriteAt(b, 0)
+}
+
+func TestVerify(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	content := bytes.Repeat([]byte("check this "), BLOCK_SIZE/5)
+	for member := MEMBER_D1; member <= MEMBER_PARITY; member++ {
+		name := memberNames[member]
+		writeTestObject(t, array, name, content)
+		if err := array.Verify(name); err != nil {
+			t.Fatalf("good object didn't verify: %v", err)
+		}
+		corruptLeg(t, array, name, member)
+		err := array.Verify(name)
+		var verr *VerifyError
+		if !errors.As(err, &verr) || !errors.Is(err, ErrChecksum) || verr.Member != member {
+			t.Errorf("expected corruption to be blamed on %s, got %v", name, err)
+		}
+	}
+
+	writeTestObject(t, array, "lopsided", content)
+	if err := os.Remove(filepath.Join(d2, "lopsided")); err != nil {
+		t.Fatalf("failed to remove link: %v", err)
+	}
+	if err := array.Verify("lopsided"); !errors.Is(err, ErrDegraded) {
+		t.Errorf("expected a degraded object, got %v", err)
+	}
+	writeTestObject(t, array, "empty", nil)
+	if err := array.Verify("empty"); err != nil {
+		t.Errorf("empty object didn't verify: %v", err)
+	}
+}
+
+func TestRemoveFile(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	writeTestObject(t, array, "dir/doomed", []byte("doomed"))
+	writeTestObject(t, array, "empty", nil)
+	for _, name := range []string{"dir/doomed", "empty"} {
+		if err := array.RemoveFile(name); err != nil {
+			t.Fatalf("failed to remove %s: %v", name, err)
+		}
+	}
+	if names, _ := array.ListFiles(""); len(names) != 0 {
+		t.Errorf("removed objects are still listed: %v", names)
+	}
+	for _, dir := range []string{d1, d2, parity} {
+		left, _ := filepath.Glob(filepath.Join(dir, "dir", "*"))
+		if len(left) != 0 {
+			t.Errorf("files left behind in %s: %v", dir, left)
+		}
+	}
+	if err := array.RemoveFile("dir/doomed"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected not found removing twice, got %v", err)
+	}
+	//the name can be used again
+	writeTestObject(t, array, "dir/doomed", []byte("back"))
+}
+
+func TestRemoveFilePrunesDirs(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	f, err := array.CreateFile("a/b/c")
+	if err != nil {
+		t.Fatalf("failed to create: %v", err)
+	}
+	f.SetMetadata(map[string]string{"Content-Type": "text/plain"})
+	if _, _, err := f.WriteAndClose([]byte("nested")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	writeTestObject(t, array, "a/keep", []byte("keep"))
+	if err := array.RemoveFile("a/b/c"); err != nil {
+		t.Fatalf("failed to remove: %v", err)
+	}
+	for _, dir := range []string{d1, d2, parity} {
+		for _, gone := range []string{filepath.Join(dir, "a", "b"), filepath.Join(metaRoot(dir), "a")} {
+			if _, err := os.Stat(gone); !os.IsNotExist(err) {
+				t.Errorf("%s is still there: %v", gone, err)
+			}
+		}
+	}
+	//"a" still has something in it
+	if got := readTestObject(t, array, "a/keep"); !bytes.Equal(got, []byte("keep")) {
+		t.Errorf("a/keep has %q", got)
+	}
+	if err := array.RemoveFile("a/keep"); err != nil {
+		t.Fatalf("failed to remove: %v", err)
+	}
+	//and now the names the directories were in the way of are free
+	writeTestObject(t, array, "a", []byte("a file now"))
+	if got := readTestObject(t, array, "a"); !bytes.Equal(got, []byte("a file now")) {
+		t.Errorf("a has %q", got)
+	}
+	for _, dir := range []string{d1, d2, parity} {
+		if left, _ := ioutil.ReadDir(metaRoot(dir)); len(left) != 0 {
+			t.Errorf("metadata dirs left behind in %s: %d", dir, len(left))
+		}
+	}
+}
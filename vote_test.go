@@ -0,0 +1,103 @@
+package raid5
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//make member's link for name point at a leg with the same name but
+//other's data, as if it had missed the last write of name
+func makeStale(t *testing.T, array *Array, member int, name string, other string) string {
+	dir := array.Dirs()[member]
+	dest, err := os.Readlink(filepath.Join(dir, other))
+	if err != nil {
+		t.Fatalf("no link for %s: %v", other, err)
+	}
+	_, l, h, _ := decodeMetadata(filepath.Base(dest))
+	leg, _ := encodeMetadata(name, l, h)
+	data, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("failed to read leg: %v", err)
+	}
+	stale := filepath.Join(dir, leg)
+	if err := ioutil.WriteFile(stale, data, 0644); err != nil {
+		t.Fatalf("failed to write leg: %v", err)
+	}
+	os.Remove(filepath.Join(dir, name))
+	if err := os.Symlink(stale, filepath.Join(dir, name)); err != nil {
+		t.Fatalf("failed to link: %v", err)
+	}
+	return stale
+}
+
+func TestMajorityWins(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	current := bytes.Repeat([]byte("new and improved "), BLOCK_SIZE/8)
+	old := bytes.Repeat([]byte("tried and tested "), BLOCK_SIZE/8)
+	writeTestObject(t, array, "obj", current)
+	writeTestObject(t, array, "old", old)
+	stale := makeStale(t, array, MEMBER_D2, "obj", "old")
+
+	if got := readTestObject(t, array, "obj"); !bytes.Equal(got, current) {
+		t.Fatalf("read the stale version")
+	}
+	if array.Stats().StaleLegs[MEMBER_D2] != 1 {
+		t.Errorf("stale leg wasn't counted: %+v", array.Stats())
+	}
+	if names, _ := array.Degraded(MEMBER_D2); len(names) != 1 || names[0] != "obj" {
+		t.Errorf("expected obj to need rebuilding on d2, got %v", names)
+	}
+	if err := array.Rebuild(MEMBER_D2); err != nil {
+		t.Fatalf("rebuild failed: %v", err)
+	}
+	if err := array.Verify("obj"); err != nil {
+		t.Errorf("still wrong after the rebuild: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale leg is still there: %v", err)
+	}
+
+	//with one member on each side there's no telling
+	makeStale(t, array, MEMBER_D2, "obj", "old")
+	dest, _ := os.Readlink(filepath.Join(parity, "obj"))
+	os.Remove(filepath.Join(parity, "obj"))
+	os.Remove(dest)
+	if _, err := array.OpenFile("obj"); !errors.Is(err, ErrNoQuorum) {
+		t.Errorf("expected no quorum, got %v", err)
+	}
+	if err := array.Rebuild(MEMBER_PARITY); err != nil {
+		t.Fatalf("rebuild failed: %v", err)
+	}
+	if job := array.Jobs()[len(array.Jobs())-1]; job.Failed != 1 {
+		t.Errorf("rebuild should have refused to pick a side: %+v", job)
+	}
+}
+
+func TestCreateOutvotesLeftover(t *testing.T) {
+	d1, d2, parity := setupTestDirs(t)
+	defer destroyTestDirs(t, d1, d2, parity)
+
+	array := NewArray(d1, d2, parity)
+	leftover := filepath.Join(d1, "again")
+	if err := ioutil.WriteFile(leftover, []byte("half written"), 0644); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	content := bytes.Repeat([]byte("second time lucky "), BLOCK_SIZE/9)
+	writeTestObject(t, array, "again", content)
+	if got, _ := ioutil.ReadFile(leftover); string(got) != "half written" {
+		t.Errorf("leftover was written over")
+	}
+	if got := readTestObject(t, array, "again"); !bytes.Equal(got, content) {
+		t.Errorf("wrong content read back")
+	}
+	if _, err := array.CreateFile("again"); !os.IsExist(err) {
+		t.Errorf("expected the object to exist now, got %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/iansmith/raid5"
+	"io"
+	"net/http"
+	"path/filepath"
+)
+
+//what GET /admin answers with
+type adminReport struct {
+	Array      string
+	BlockSize  int
+	Durability string
+	Members    []memberReport
+	Spares     []string
+	//the last object copied onto a rebuilding member
+	RebuildCursor string `json:",omitempty"`
+	Jobs          []raid5.Job
+}
+
+type memberReport struct {
+	Name   string
+	Dir    string
+	State  string
+	Errors int
+	Free   uint64
+	Size   uint64
+	//objects known to be missing their leg on this member, -1 if the
+	//other members couldn't be asked
+	Degraded int
+}
+
+//GET /admin is everything there is to know about the array as JSON
+func adminStatus(w http.ResponseWriter, req *http.Request) {
+	report := adminReport{
+		Array:         array.ID(),
+		BlockSize:     raid5.BLOCK_SIZE,
+		Durability:    array.Durability.String(),
+		Spares:        array.Spares(),
+		RebuildCursor: array.RebuildCursor(),
+		Jobs:          array.Jobs(),
+	}
+	for i, m := range array.Members() {
+		degraded := -1
+		if names, err := array.Degraded(i); err == nil {
+			degraded = len(names)
+		}
+		report.Members = append(report.Members, memberReport{
+			Name:     raid5.MemberName(i),
+			Dir:      m.Dir,
+			State:    m.State.String(),
+			Errors:   m.Errors,
+			Free:     m.Free,
+			Size:     m.Size,
+			Degraded: degraded,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+}
+
+func adminError(w http.ResponseWriter, code int, err error) {
+	w.WriteHeader(code)
+	io.WriteString(w, fmt.Sprintf("%s", err))
+}
+
+//the member named by the member parameter, d1, d2, parity or a number
+func formMember(w http.ResponseWriter, req *http.Request) (int, bool) {
+	member, err := raid5.ParseMember(req.FormValue("member"))
+	if err != nil {
+		adminError(w, http.StatusBadRequest, err)
+		return 0, false
+	}
+	return member, true
+}
+
+//the directory in the named parameter, which has to be a full path
+//since it's the server that's going to use it
+func formDir(w http.ResponseWriter, req *http.Request, param string) (string, bool) {
+	dir := req.FormValue(param)
+	if !filepath.IsAbs(dir) {
+		adminError(w, http.StatusBadRequest, fmt.Errorf("%s needs to be a full path, got %q", param, dir))
+		return "", false
+	}
+	return filepath.Clean(dir), true
+}
+
+//POST /admin/fail?member=d2 takes a member out of service, a spare
+//takes over if there is one
+func failMember(w http.ResponseWriter, req *http.Request) {
+	member, ok := formMember(w, req)
+	if !ok {
+		return
+	}
+	if err := array.SetMemberState(member, raid5.MEMBER_FAILED); err != nil {
+		adminError(w, http.StatusConflict, err)
+		return
+	}
+	io.WriteString(w, "ok")
+}
+
+//POST /admin/replace?member=d1&new=/some/dir starts swapping a new
+//directory in for a member, the copy carries on after we answer
+func replaceMember(w http.ResponseWriter, req *http.Request) {
+	member, ok := formMember(w, req)
+	if !ok {
+		return
+	}
+	dir, ok := formDir(w, req, "new")
+	if !ok {
+		return
+	}
+	if err := array.ReplaceMember(member, dir); err != nil {
+		adminError(w, http.StatusConflict, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	io.WriteString(w, "ok")
+}
+
+//POST /admin/spares?dir=/some/dir gives the array another spare
+func addSpare(w http.ResponseWriter, req *http.Request) {
+	dir, ok := formDir(w, req, "dir")
+	if !ok {
+		return
+	}
+	if err := array.AddSpare(dir); err != nil {
+		adminError(w, http.StatusConflict, err)
+		return
+	}
+	io.WriteString(w, "ok")
+}
+
+//DELETE /admin/spares?dir=/some/dir takes back a spare that hasn't been
+//used
+func removeSpare(w http.ResponseWriter, req *http.Request) {
+	dir, ok := formDir(w, req, "dir")
+	if !ok {
+		return
+	}
+	if err := array.RemoveSpare(dir); err != nil {
+		adminError(w, http.StatusNotFound, err)
+		return
+	}
+	io.WriteString(w, "ok")
+}
+
+//POST /admin/scrub checks every object in the background, GET /admin
+//shows how it's going
+func startScrub(w http.ResponseWriter, req *http.Request) {
+	if err := array.StartScrub(); err != nil {
+		adminError(w, http.StatusConflict, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	io.WriteString(w, "ok")
+}
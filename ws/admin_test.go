@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/iansmith/raid5"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestAdmin(t *testing.T) {
+	srv := setupServer(t)
+	admin := httptest.NewServer(adminRoutes())
+	defer admin.Close()
+	spare := tempDirs(t, 1)[0]
+
+	//none of it is on the public address
+	if resp, _ := do(t, http.MethodGet, srv.URL+"/admin", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected /admin not to be on the public address, got %s", resp.Status)
+	}
+	if resp, _ := do(t, http.MethodPost, srv.URL+"/admin/fail?member=d1", nil); resp.StatusCode == http.StatusOK {
+		t.Errorf("a member was failed from the public address")
+	}
+
+	resp, body := do(t, http.MethodGet, admin.URL+"/admin", nil)
+	var report adminReport
+	if err := json.Unmarshal(body, &report); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("bad report: %s %v", resp.Status, err)
+	}
+	if report.Array != array.ID() || len(report.Members) != 3 || report.Members[0].State != "online" {
+		t.Errorf("wrong report: %+v", report)
+	}
+
+	for _, step := range []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{http.MethodPost, "/admin/spares?dir=relative/dir", http.StatusBadRequest},
+		{http.MethodPost, "/admin/spares?dir=" + url.QueryEscape(spare), http.StatusOK},
+		{http.MethodDelete, "/admin/spares?dir=" + url.QueryEscape(spare), http.StatusOK},
+		{http.MethodDelete, "/admin/spares?dir=" + url.QueryEscape(spare), http.StatusNotFound},
+		{http.MethodPost, "/admin/scrub", http.StatusAccepted},
+		{http.MethodPost, "/admin/fail?member=d9", http.StatusBadRequest},
+		{http.MethodPost, "/admin/fail?member=d2", http.StatusOK},
+	} {
+		if resp, body := do(t, step.method, admin.URL+step.path, nil); resp.StatusCode != step.code {
+			t.Errorf("%s %s: expected %d, got %s: %s", step.method, step.path, step.code, resp.Status, body)
+		}
+	}
+	array.Wait()
+	if len(array.Spares()) != 0 {
+		t.Errorf("spare should have been taken back: %v", array.Spares())
+	}
+	if state := array.Members()[raid5.MEMBER_D2].State; state != raid5.MEMBER_FAILED {
+		t.Errorf("expected d2 to be failed, got %v", state)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/iansmith/raid5"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//config is everything about how the server runs.  it comes from a JSON
+//file (see -config) with the flags laid over the top of it.
+type config struct {
+	//address to listen on, like ":8080"
+	Listen string
+	//address for the /admin requests, which can fail members and
+	//start rebuilds, so by default only this machine can get to them
+	Admin string
+	//d1, d2 and parity, in that order
+	Members []string
+	//directories that take over for a member that fails
+	Spares []string
+	//read-only: the block size is fixed when the library is built and
+	//this can't change it.  it's only a sanity check, so a config
+	//written for some other build gets caught instead of misreading
+	//the array.  leave it out to take the build's
+	BlockSize int
+	//default durability for writes, see raid5.ParseDurability
+	Durability string
+	//biggest object a PUT can make, 0 for no limit
+	MaxObjectSize int64
+	//how many PUTs can be in progress at once, 0 for no limit
+	MaxWrites int
+	//read from parity instead of a data member that's taking longer
+	//than this, "0" to always wait.  see raid5.Array.HedgeAfter.
+	HedgeAfter string
+	//how often to look for members that have disappeared
+	CheckInterval string
+	//how long requests in progress get to finish when we are told to
+	//stop
+	ShutdownTimeout string
+	//start even if some members have no superblock, they get marked
+	//failed (and replaced by spares, if there are any)
+	AllowMissingMembers bool
+	//look for debris left by crashes before starting, and clear it up
+	Fsck bool
+	//make new directories in the temp dir, and remove them on exit.
+	//only for trying things out.
+	Temp bool
+}
+
+func defaultConfig() *config {
+	return &config{
+		Listen:          ":8080",
+		Admin:           "127.0.0.1:8081",
+		BlockSize:       raid5.BLOCK_SIZE,
+		Durability:      raid5.DURABILITY_FSYNC_DIRS.String(),
+		MaxObjectSize:   1 << 30,
+		MaxWrites:       64,
+		HedgeAfter:      "0",
+		CheckInterval:   "30s",
+		ShutdownTimeout: "30s",
+	}
+}
+
+//a comma separated list of directories as a flag
+type dirList []string
+
+func (self *dirList) String() string {
+	return strings.Join(*self, ",")
+}
+
+func (self *dirList) Set(s string) error {
+	*self = nil
+	for _, dir := range strings.Split(s, ",") {
+		if dir != "" {
+			*self = append(*self, dir)
+		}
+	}
+	return nil
+}
+
+//read the config file named by -config, if any, then apply the flags
+//that were given on the command line
+func loadConfig(args []string) (*config, error) {
+	result := defaultConfig()
+	fs := flag.NewFlagSet("ws", flag.ContinueOnError)
+	path := fs.String("config", "", "JSON config file, the other flags override it")
+	fs.String("listen", result.Listen, "address to listen on")
+	fs.String("admin", result.Admin, "address to listen on for /admin, keep it private")
+	//not straight into result, the file would overwrite them
+	var members, spares dirList
+	fs.Var(&members, "members", "member directories, d1,d2,parity")
+	fs.Var(&spares, "spares", "spare directories, comma separated")
+	fs.Int("block-size", result.BlockSize, "block size, a sanity check only: it can't change the build's, just has to match it")
+	fs.String("durability", result.Durability, "default durability: none, fsync or fsync-dirs")
+	fs.Int64("max-object-size", result.MaxObjectSize, "biggest object a PUT can make, 0 for no limit")
+	fs.Int("max-writes", result.MaxWrites, "most PUTs in progress at once, 0 for no limit")
+	fs.String("hedge-after", result.HedgeAfter, "read from parity instead of a data member slower than this, 0 to always wait")
+	fs.String("check-interval", result.CheckInterval, "how often to look for missing members")
+	fs.String("shutdown-timeout", result.ShutdownTimeout, "how long requests in progress get to finish on SIGTERM")
+	fs.Bool("allow-missing-members", false, "start even if some members have no superblock")
+	fs.Bool("fsck", false, "check the array for debris from crashes and repair it before starting")
+	fs.Bool("temp", false, "use new directories in the temp dir and remove them on exit")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if *path != "" {
+		raw, err := ioutil.ReadFile(*path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, result); err != nil {
+			return nil, fmt.Errorf("%s: %v", *path, err)
+		}
+	}
+	//only the flags that were actually given win over the file
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		v := f.Value.String()
+		switch f.Name {
+		case "listen":
+			result.Listen = v
+		case "admin":
+			result.Admin = v
+		case "members":
+			result.Members = members
+		case "spares":
+			result.Spares = spares
+		case "block-size":
+			_, err = fmt.Sscan(v, &result.BlockSize)
+		case "durability":
+			result.Durability = v
+		case "max-object-size":
+			_, err = fmt.Sscan(v, &result.MaxObjectSize)
+		case "max-writes":
+			_, err = fmt.Sscan(v, &result.MaxWrites)
+		case "hedge-after":
+			result.HedgeAfter = v
+		case "check-interval":
+			result.CheckInterval = v
+		case "shutdown-timeout":
+			result.ShutdownTimeout = v
+		case "allow-missing-members":
+			result.AllowMissingMembers = v == "true"
+		case "fsck":
+			result.Fsck = v == "true"
+		case "temp":
+			result.Temp = v == "true"
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, result.validate()
+}
+
+//make sure the config makes sense before we touch any of the
+//directories
+func (self *config) validate() error {
+	if self.Listen == "" {
+		return errors.New("no address to listen on")
+	}
+	if self.Admin == "" {
+		return errors.New("no address to listen on for /admin")
+	}
+	if self.Admin == self.Listen {
+		return fmt.Errorf("/admin can't be on the same address as everything else, %s", self.Listen)
+	}
+	if self.Temp {
+		if len(self.Members) != 0 || len(self.Spares) != 0 {
+			return errors.New("temp can't be used with members or spares")
+		}
+	} else if len(self.Members) != 3 {
+		return fmt.Errorf("need three member directories (d1, d2, parity), got %d", len(self.Members))
+	}
+	//the links in the members point at full paths, so relative ones
+	//won't do
+	for _, dirs := range [][]string{self.Members, self.Spares} {
+		for i, dir := range dirs {
+			abs, err := filepath.Abs(dir)
+			if err != nil {
+				return err
+			}
+			dirs[i] = abs
+		}
+	}
+	seen := make(map[string]bool)
+	for _, dir := range append(append([]string{}, self.Members...), self.Spares...) {
+		if seen[dir] {
+			return fmt.Errorf("%s is used more than once", dir)
+		}
+		seen[dir] = true
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+	}
+	if self.BlockSize != raid5.BLOCK_SIZE {
+		return fmt.Errorf("block size %d is not supported, this build uses %d (BlockSize is only a check that the config matches the build, it can't change it)", self.BlockSize, raid5.BLOCK_SIZE)
+	}
+	if _, err := raid5.ParseDurability(self.Durability); err != nil {
+		return err
+	}
+	if self.MaxObjectSize < 0 {
+		return errors.New("max object size can't be negative")
+	}
+	if self.MaxWrites < 0 {
+		return errors.New("max writes can't be negative")
+	}
+	if d, err := time.ParseDuration(self.HedgeAfter); err != nil || d < 0 {
+		return fmt.Errorf("bad hedge after %q", self.HedgeAfter)
+	}
+	if d, err := time.ParseDuration(self.CheckInterval); err != nil || d <= 0 {
+		return fmt.Errorf("bad check interval %q", self.CheckInterval)
+	}
+	if d, err := time.ParseDuration(self.ShutdownTimeout); err != nil || d < 0 {
+		return fmt.Errorf("bad shutdown timeout %q", self.ShutdownTimeout)
+	}
+	return nil
+}
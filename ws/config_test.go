@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//n new directories, removed when the test is done
+func tempDirs(t *testing.T, n int) []string {
+	result := []string{}
+	for i := 0; i < n; i++ {
+		dir, err := ioutil.TempDir("", "ws")
+		if err != nil {
+			t.Fatalf("failed to make a temp dir: %v", err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		result = append(result, dir)
+	}
+	return result
+}
+
+func TestFlagsOverrideConfigFile(t *testing.T) {
+	dirs := tempDirs(t, 7)
+	file := filepath.Join(dirs[6], "config.json")
+	raw, _ := json.Marshal(map[string]interface{}{
+		"Listen":  ":9999",
+		"Members": dirs[0:3],
+		"Spares":  dirs[3:4],
+	})
+	if err := ioutil.WriteFile(file, raw, 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	got, err := loadConfig([]string{"-config", file, "-members", dirs[3] + "," + dirs[4] + "," + dirs[5],
+		"-spares", dirs[0]})
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if len(got.Members) != 3 || got.Members[0] != dirs[3] || got.Members[2] != dirs[5] {
+		t.Errorf("expected the members from the flag, got %v", got.Members)
+	}
+	if len(got.Spares) != 1 || got.Spares[0] != dirs[0] {
+		t.Errorf("expected the spares from the flag, got %v", got.Spares)
+	}
+	if got.Listen != ":9999" {
+		t.Errorf("expected the address from the file, got %s", got.Listen)
+	}
+
+	//without the flags the file wins
+	got, err = loadConfig([]string{"-config", file, "-listen", ":1234"})
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if len(got.Members) != 3 || got.Members[0] != dirs[0] || got.Listen != ":1234" {
+		t.Errorf("expected the members from the file and the address from the flag, got %v %s", got.Members, got.Listen)
+	}
+}
+
+func TestAdminAddress(t *testing.T) {
+	dirs := tempDirs(t, 3)
+	members := dirs[0] + "," + dirs[1] + "," + dirs[2]
+	got, err := loadConfig([]string{"-members", members})
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if got.Admin != "127.0.0.1:8081" {
+		t.Errorf("expected /admin to be on loopback by default, got %s", got.Admin)
+	}
+	if _, err := loadConfig([]string{"-members", members, "-listen", ":9000", "-admin", ":9000"}); err == nil {
+		t.Errorf("expected /admin on the public address to be refused")
+	}
+}
@@ -1,6 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/bmizerany/pat"
 	"github.com/iansmith/raid5"
@@ -9,51 +15,241 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 var (
-	data1, data2, parity string //pathnames to the directories
+	cfg   *config
+	array *raid5.Array
+	//one token per PUT that's allowed to be running, nil for no limit
+	writeSlots chan struct{}
+	//PUTs that haven't finished, shutdown waits for them
+	writes sync.WaitGroup
 )
 
-//we just use the directories in the temp dir since this is a test
-//progarm
-func init() {
+//make the directories for -temp
+func makeTempDirs() error {
+	for i := 0; i < 4; i++ {
+		dir, err := ioutil.TempDir("", "raid5")
+		if err != nil {
+			return err
+		}
+		if i < 3 {
+			cfg.Members = append(cfg.Members, dir)
+		} else {
+			cfg.Spares = append(cfg.Spares, dir)
+		}
+	}
+	return nil
+}
+
+func removeTempDirs() {
+	for _, dir := range append(append([]string{}, cfg.Members...), cfg.Spares...) {
+		os.RemoveAll(dir)
+	}
+}
+
+//open the array the config describes, refusing to go any further if
+//it looks like some of it is missing
+func openArray() error {
+	d1, d2, parity := cfg.Members[0], cfg.Members[1], cfg.Members[2]
+	//the raid5 command can be working on the same members
+	raid5.Locks.LockFiles = true
+	if err := raid5.CheckArray(d1, d2, parity); err != nil {
+		if !errors.Is(err, raid5.ErrHalfInitialized) || !cfg.AllowMissingMembers {
+			return err
+		}
+		log.Printf("starting anyway: %v", err)
+	}
 	var err error
-	data1, err = ioutil.TempDir("", "raid5")
+	array, err = raid5.OpenArray(d1, d2, parity)
 	if err != nil {
-		log.Fatalf("creating data dir: %v", err)
+		return err
 	}
-	data2, err = ioutil.TempDir("", "raid5")
-	if err != nil {
-		log.Fatalf("creating data dir2: %v", err)
+	array.Durability, _ = raid5.ParseDurability(cfg.Durability)
+	array.HedgeAfter, _ = time.ParseDuration(cfg.HedgeAfter)
+	known := append(array.Dirs(), array.Spares()...)
+	for _, spare := range cfg.Spares {
+		already := false
+		for _, dir := range known {
+			already = already || dir == spare
+		}
+		if !already {
+			if err := array.AddSpare(spare); err != nil {
+				return err
+			}
+		}
 	}
-	parity, err = ioutil.TempDir("", "raid5")
-	if err != nil {
-		log.Fatalf("creating parity dir: %v", err)
+	if cfg.Fsck {
+		report, err := array.Fsck(true)
+		if err != nil {
+			return err
+		}
+		for _, problem := range report.Problems {
+			log.Printf("fsck: %s", problem)
+		}
+		log.Printf("fsck: %d objects, %d problems, %d left alone", report.Objects, len(report.Problems), report.Unfixed())
+	}
+	if cfg.MaxWrites > 0 {
+		writeSlots = make(chan struct{}, cfg.MaxWrites)
+	}
+	return nil
+}
+
+//names can have slashes in them, so we can't use a pat variable for
+//them.  everything after the /raid5/ is the name.
+func objectName(req *http.Request) string {
+	return strings.TrimPrefix(req.URL.Path, "/raid5/")
+}
+
+//bad names are the client's fault, so they get a 400 rather than
+//making it all the way into the library
+func badName(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, raid5.ErrInvalidName) {
+		return false
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	io.WriteString(w, fmt.Sprintf("%s", err))
+	return true
+}
+
+//errors from the library that are about the object rather than the
+//server get their own status codes, anything else gets code
+func objectError(w http.ResponseWriter, err error, code int) {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		code = http.StatusNotFound
+	case errors.Is(err, os.ErrExist):
+		code = http.StatusConflict
+	}
+	w.WriteHeader(code)
+	io.WriteString(w, fmt.Sprintf("%v", err))
+}
+
+//an object's ETag is the md5 of its content, clients can check what
+//they get (or what they sent) against it
+func etag(hash []byte) string {
+	if hash == nil {
+		//the library doesn't bother for empty objects
+		sum := md5.Sum(nil)
+		hash = sum[:]
+	}
+	return `"` + hex.EncodeToString(hash) + `"`
+}
+
+//headers from a PUT that are kept with the object and sent back with
+//it, along with any that start with META_PREFIX
+var storedHeaders = []string{"Content-Type", "Content-Encoding", "Cache-Control"}
+
+const META_PREFIX = "X-Raid5-Meta-"
+
+//the headers of a PUT that get kept, as metadata for the library
+func headerMetadata(h http.Header) map[string]string {
+	result := make(map[string]string)
+	for key, values := range h {
+		if strings.HasPrefix(key, META_PREFIX) {
+			result[key] = strings.Join(values, ", ")
+		}
+	}
+	for _, key := range storedHeaders {
+		if v := h.Get(key); v != "" {
+			result[key] = v
+		}
+	}
+	return result
+}
+
+//send back what headerMetadata kept.  anything in there that isn't one
+//of ours came from somewhere else and is left alone.
+func replayMetadata(w http.ResponseWriter, meta map[string]string) {
+	for key, value := range meta {
+		stored := strings.HasPrefix(key, META_PREFIX)
+		for _, h := range storedHeaders {
+			stored = stored || key == h
+		}
+		if stored {
+			w.Header().Set(key, value)
+		}
 	}
 }
 
 func putData(w http.ResponseWriter, req *http.Request) {
-	n := req.URL.Query().Get(":name")
-	obj, err := raid5.CreateFile(data1, data2, parity, n)
+	n := objectName(req)
+	if badName(w, raid5.ValidateName(n)) {
+		return
+	}
+	if cfg.MaxObjectSize > 0 && req.ContentLength > cfg.MaxObjectSize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		io.WriteString(w, fmt.Sprintf("objects can't be bigger than %d bytes", cfg.MaxObjectSize))
+		return
+	}
+	writes.Add(1)
+	defer writes.Done()
+	if writeSlots != nil {
+		select {
+		case writeSlots <- struct{}{}:
+			defer func() { <-writeSlots }()
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, "too many writes in progress")
+			return
+		}
+	}
+	//clients that know better can ask for more or less durability than
+	//the default for this one object
+	durability := array.Durability
+	if h := req.Header.Get("X-Raid5-Durability"); h != "" {
+		var err error
+		durability, err = raid5.ParseDurability(h)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, fmt.Sprintf("%s", err))
+			return
+		}
+	}
+	obj, err := array.CreateFileContext(req.Context(), n)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		io.WriteString(w, fmt.Sprintf("%s", err))
+		objectError(w, err, http.StatusBadRequest)
 		return
 	}
+	obj.SetDurability(durability)
+	obj.SetMetadata(headerMetadata(req.Header))
 	if req.ContentLength == 0 {
-		obj.Close()
+		if err := obj.Close(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, fmt.Sprintf("%v", err))
+			return
+		}
+		w.Header().Set("ETag", etag(nil))
 		io.WriteString(w, "ok")
 		return
 	}
-	buffer, err := ioutil.ReadAll(req.Body)
+	//the content length might not have been given, so read one byte
+	//more than we are willing to take to find out if it's too much
+	body := io.Reader(req.Body)
+	if cfg.MaxObjectSize > 0 {
+		body = io.LimitReader(body, cfg.MaxObjectSize+1)
+	}
+	buffer, err := ioutil.ReadAll(body)
 	req.Body.Close()
 	if err != nil {
+		obj.Abort()
 		w.WriteHeader(http.StatusInternalServerError)
 		io.WriteString(w, "failed to read the body supplied")
 		return
 	}
-	_, _, err = obj.WriteAndClose(buffer)
+	if cfg.MaxObjectSize > 0 && int64(len(buffer)) > cfg.MaxObjectSize {
+		obj.Abort()
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		io.WriteString(w, fmt.Sprintf("objects can't be bigger than %d bytes", cfg.MaxObjectSize))
+		return
+	}
+	_, hash, err := obj.WriteAndClose(buffer)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		io.WriteString(w, fmt.Sprintf("%v", err))
@@ -62,47 +258,201 @@ func putData(w http.ResponseWriter, req *http.Request) {
 
 	log.Printf("wrote: %s\n", n)
 	//everything is ok
+	w.Header().Set("ETag", etag(hash))
 	io.WriteString(w, "ok")
 }
 
-func readData(w http.ResponseWriter, req *http.Request) {
-	n := req.URL.Query().Get(":name")
-	obj, err := raid5.OpenFile(data1, data2, parity, n)
+//a GET on a "directory" (empty name or trailing slash) lists the names
+//that start with it, one per line
+func listData(w http.ResponseWriter, req *http.Request, prefix string) {
+	names, err := array.ListFiles(prefix)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		io.WriteString(w, fmt.Sprintf("%s", err))
 		return
 	}
-	if obj.Size() == 0 {
+	for _, name := range names {
+		io.WriteString(w, name+"\n")
+	}
+}
+
+func readData(w http.ResponseWriter, req *http.Request) {
+	n := objectName(req)
+	if n == "" || strings.HasSuffix(n, "/") {
+		listData(w, req, n)
+		return
+	}
+	if badName(w, raid5.ValidateName(n)) {
+		return
+	}
+	obj, err := array.OpenFileContext(req.Context(), n)
+	if err != nil {
+		objectError(w, err, http.StatusBadRequest)
 		return
 	}
-	//could get fancy and do this in blobs, but not bothering to
-	//do that extra looping
-	buffer := make([]byte, obj.Size())
-	_, err = obj.ReadFile(buffer, 0)
+	//other requests for this name can't write it until we close it
+	defer obj.Close()
+
+	//a whole object is read in one go, so it's checked against its hash
+	//before any of it goes out.  HEADs and ranges only read what they
+	//need.  ServeContent takes care of the headers for all of them.
+	content := io.ReadSeeker(io.NewSectionReader(&objectReader{ctx: req.Context(), obj: obj}, 0, obj.Size()))
+	if req.Method == http.MethodGet && req.Header.Get("Range") == "" && obj.Size() > 0 {
+		buffer := make([]byte, obj.Size())
+		_, err = obj.ReadFileContext(req.Context(), buffer, 0)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, fmt.Sprintf("%v", err))
+			return
+		}
+		content = bytes.NewReader(buffer)
+	}
+	meta, err := obj.Metadata()
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		io.WriteString(w, fmt.Sprintf("%v", err))
 		return
 	}
-	w.Write(buffer)
+	replayMetadata(w, meta)
+	//ServeContent leaves the length out when there's a Content-Encoding
+	if w.Header().Get("Content-Encoding") != "" && req.Header.Get("Range") == "" {
+		w.Header().Set("Content-Length", strconv.FormatInt(obj.Size(), 10))
+	}
+	w.Header().Set("ETag", etag(obj.Hash()))
+	http.ServeContent(w, req, "", time.Time{}, content)
 	log.Printf("finished writing %s to client", n)
 }
 
-func main() {
+//part of an open object for http.ServeContent, reads give up when the
+//request does
+type objectReader struct {
+	ctx context.Context
+	obj interface {
+		ReadFileContext(ctx context.Context, out []byte, offset int64) (int64, error)
+	}
+}
+
+func (self *objectReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := self.obj.ReadFileContext(self.ctx, p, off)
+	if err == nil && n < int64(len(p)) {
+		err = io.EOF
+	}
+	return int(n), err
+}
+
+func deleteData(w http.ResponseWriter, req *http.Request) {
+	n := objectName(req)
+	if badName(w, raid5.ValidateName(n)) {
+		return
+	}
+	if err := array.RemoveFile(n); err != nil {
+		objectError(w, err, http.StatusInternalServerError)
+		return
+	}
+	log.Printf("removed: %s\n", n)
+	io.WriteString(w, "ok")
+}
+
+//what the server answers on -listen
+func routes() http.Handler {
+	m := pat.New()
+	m.Get("/raid5/", instrument(readData))
+	m.Put("/raid5/", instrument(putData))
+	m.Del("/raid5/", instrument(deleteData))
+	m.Get("/metrics", http.HandlerFunc(serveMetrics))
+	return m
+}
+
+//what it answers on -admin, see admin.go
+func adminRoutes() http.Handler {
 	m := pat.New()
-	m.Get("/raid5/:name", http.HandlerFunc(readData))
-	m.Put("/raid5/:name", http.HandlerFunc(putData))
-	http.Handle("/", m)
-
-	defer func() {
-		os.RemoveAll(data1)
-		os.RemoveAll(data2)
-		os.RemoveAll(parity)
+	m.Get("/admin", http.HandlerFunc(adminStatus))
+	m.Post("/admin/fail", http.HandlerFunc(failMember))
+	m.Post("/admin/replace", http.HandlerFunc(replaceMember))
+	m.Post("/admin/spares", http.HandlerFunc(addSpare))
+	m.Del("/admin/spares", http.HandlerFunc(removeSpare))
+	m.Post("/admin/scrub", http.HandlerFunc(startScrub))
+	return m
+}
+
+//look for members that have gone away every so often
+func checkMembers(interval time.Duration) {
+	for range time.Tick(interval) {
+		array.CheckMembers()
+	}
+}
+
+func main() {
+	var err error
+	cfg, err = loadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("bad configuration: %v", err)
+	}
+	if cfg.Temp {
+		if err := makeTempDirs(); err != nil {
+			log.Fatalf("creating temp dirs: %v", err)
+		}
+		defer removeTempDirs()
+	}
+	if err := openArray(); err != nil {
+		log.Fatalf("opening array: %v", err)
+	}
+
+	dirs := array.Dirs()
+	log.Printf("data directories for the server:\n%s\n%s\n(PARITY %s)\n(SPARES %s)\n",
+		dirs[0], dirs[1], dirs[2], strings.Join(array.Spares(), ", "))
+	for i, m := range array.Members() {
+		log.Printf("member %d (%s) is %s", i, m.Dir, m.State)
+	}
+	interval, _ := time.ParseDuration(cfg.CheckInterval)
+	go checkMembers(interval)
+
+	srv := &http.Server{Addr: cfg.Listen, Handler: routes()}
+	adminSrv := &http.Server{Addr: cfg.Admin, Handler: adminRoutes()}
+	stopped := make(chan struct{})
+	go shutdown(stopped, srv, adminSrv)
+	go func() {
+		if err := adminSrv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("admin listen and serve: %v", err)
+		}
 	}()
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatalf("listen and serve: %v", err)
+	}
+	<-stopped
+	//connections that got closed on us leave their PUTs rolling back
+	writes.Wait()
+	if err := array.Close(); err != nil {
+		log.Printf("closing array: %v", err)
+	}
+	log.Printf("shut down")
+}
 
-	log.Printf("data directories for the server:\n%s\n%s\n(PARITY %s)\n",
-		data1, data2, parity)
-	log.Fatalf("returned from listen and serve",
-		http.ListenAndServe(":8080", nil))
+//wait for a signal, then stopServers
+func shutdown(stopped chan struct{}, servers ...*http.Server) {
+	defer close(stopped)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigs
+	signal.Stop(sigs)
+	timeout, _ := time.ParseDuration(cfg.ShutdownTimeout)
+	log.Printf("got %v, finishing requests in progress (waiting up to %v)", sig, timeout)
+	stopServers(timeout, servers...)
+}
+
+//stop taking new requests and give the ones in progress timeout to
+//finish.  anything still going after that has its connection closed,
+//which makes a PUT that's still reading its body roll back.
+func stopServers(timeout time.Duration, servers ...*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("giving up on requests still in progress on %s: %v", srv.Addr, err)
+			srv.Close()
+		}
+	}
 }
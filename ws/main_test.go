@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"github.com/iansmith/raid5"
+	"github.com/iansmith/raid5/client"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//a server for a new array in temp directories, it all goes away when
+//the test is done
+func setupServer(t *testing.T, args ...string) *httptest.Server {
+	dirs := tempDirs(t, 3)
+	var err error
+	cfg, err = loadConfig(append([]string{"-members", strings.Join(dirs, ","), "-durability", "none"}, args...))
+	if err != nil {
+		t.Fatalf("bad config: %v", err)
+	}
+	if err := openArray(); err != nil {
+		t.Fatalf("failed to open array: %v", err)
+	}
+	srv := httptest.NewServer(routes())
+	t.Cleanup(func() {
+		srv.Close()
+		if err := array.Close(); err != nil {
+			t.Errorf("failed to close array: %v", err)
+		}
+		raid5.Locks.LockFiles = false
+	})
+	return srv
+}
+
+//send a request without the client, to see exactly what comes back
+func do(t *testing.T, method string, url string, header http.Header) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatalf("bad request: %v", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body of %s %s: %v", method, url, err)
+	}
+	return resp, body
+}
+
+func TestClientAgainstServer(t *testing.T) {
+	srv := setupServer(t)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+
+	content := bytes.Repeat([]byte("all the way through "), raid5.BLOCK_SIZE/7)
+	if err := c.Put(ctx, "some dir/obj", bytes.NewReader(content)); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	if err := c.Put(ctx, "some dir/obj", bytes.NewReader(content)); !errors.Is(err, os.ErrExist) {
+		t.Errorf("expected a second put to fail with ErrExist, got %v", err)
+	}
+	if err := c.Put(ctx, "empty", bytes.NewReader(nil)); err != nil {
+		t.Fatalf("failed to put an empty object: %v", err)
+	}
+
+	//the client checks what it gets against the ETag
+	r, err := c.Get(ctx, "some dir/obj")
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("wrong content read back: %v", err)
+	}
+	r, err = c.GetRange(ctx, "some dir/obj", 4, 3)
+	if err != nil {
+		t.Fatalf("failed to get a range: %v", err)
+	}
+	got, _ = ioutil.ReadAll(r)
+	r.Close()
+	if string(got) != "the" {
+		t.Errorf("wrong range read back: %q", got)
+	}
+	for name, want := range map[string][]byte{"some dir/obj": content, "empty": nil} {
+		info, err := c.Stat(ctx, name)
+		if err != nil {
+			t.Fatalf("failed to stat %s: %v", name, err)
+		}
+		sum := md5.Sum(want)
+		if info.Size != int64(len(want)) || !bytes.Equal(info.Hash, sum[:]) {
+			t.Errorf("wrong size or hash for %s: %+v", name, info)
+		}
+	}
+	names, err := c.List(ctx, "some dir/")
+	if err != nil || len(names) != 1 || names[0] != "some dir/obj" {
+		t.Errorf("wrong listing: %v %v", names, err)
+	}
+
+	if err := c.Delete(ctx, "some dir/obj"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if _, err := c.Stat(ctx, "some dir/obj"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the object to be gone, got %v", err)
+	}
+	if err := c.Delete(ctx, "some dir/obj"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a second delete to fail with ErrNotExist, got %v", err)
+	}
+}
+
+func TestHeadAndRange(t *testing.T) {
+	srv := setupServer(t)
+	content := bytes.Repeat([]byte("0123456789"), raid5.BLOCK_SIZE/4)
+	if err := client.New(srv.URL).Put(context.Background(), "obj", bytes.NewReader(content)); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	sum := md5.Sum(content)
+	tag := etag(sum[:])
+	url := srv.URL + "/raid5/obj"
+
+	resp, body := do(t, http.MethodHead, url, nil)
+	if resp.StatusCode != http.StatusOK || len(body) != 0 {
+		t.Errorf("wrong HEAD response: %s, %d bytes", resp.Status, len(body))
+	}
+	if resp.ContentLength != int64(len(content)) || resp.Header.Get("ETag") != tag {
+		t.Errorf("wrong HEAD headers: %v", resp.Header)
+	}
+
+	//a range that crosses from one stripe into the next
+	from := int64(raid5.BLOCK_SIZE - 5)
+	resp, body = do(t, http.MethodGet, url, http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", from, from+9)}})
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("expected 206 for a range, got %s", resp.Status)
+	}
+	if !bytes.Equal(body, content[from:from+10]) {
+		t.Errorf("wrong range: %q", body)
+	}
+	want := fmt.Sprintf("bytes %d-%d/%d", from, from+9, len(content))
+	if got := resp.Header.Get("Content-Range"); got != want {
+		t.Errorf("expected Content-Range %s, got %s", want, got)
+	}
+	if resp.Header.Get("ETag") != tag {
+		t.Errorf("a range should have the object's ETag, got %s", resp.Header.Get("ETag"))
+	}
+
+	//the client can skip what it already has
+	resp, _ = do(t, http.MethodGet, url, http.Header{"If-None-Match": {tag}})
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected 304 for a matching ETag, got %s", resp.Status)
+	}
+	resp, _ = do(t, http.MethodGet, url, http.Header{"Range": {"bytes=999999999-"}})
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("expected 416 for a range past the end, got %s", resp.Status)
+	}
+
+	resp, _ = do(t, http.MethodDelete, url, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("failed to delete: %s", resp.Status)
+	}
+	resp, _ = do(t, http.MethodHead, url, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 after delete, got %s", resp.Status)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	srv := setupServer(t)
+	metrics = &serverMetrics{requests: make(map[string]*requestMetrics)}
+	c := client.New(srv.URL)
+	ctx := context.Background()
+	if err := c.Put(ctx, "obj", strings.NewReader("hello")); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	do(t, http.MethodGet, srv.URL+"/raid5/nope", nil)
+	//reading it without d1 means working it out from parity
+	if err := os.Remove(filepath.Join(array.Dirs()[raid5.MEMBER_D1], "obj")); err != nil {
+		t.Fatalf("failed to remove link: %v", err)
+	}
+	if resp, body := do(t, http.MethodGet, srv.URL+"/raid5/obj", nil); string(body) != "hello" {
+		t.Fatalf("wrong content read back: %s %q", resp.Status, body)
+	}
+
+	resp, body := do(t, http.MethodGet, srv.URL+"/metrics", nil)
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("wrong content type for metrics: %s", resp.Header.Get("Content-Type"))
+	}
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(string(body), "\n") {
+		lines[line] = true
+	}
+	for _, want := range []string{
+		"# TYPE raid5_http_requests_total counter",
+		`raid5_http_requests_total{method="PUT",code="200"} 1`,
+		`raid5_http_requests_total{method="GET",code="200"} 1`,
+		`raid5_http_requests_total{method="GET",code="404"} 1`,
+		`raid5_http_received_bytes_total{method="PUT"} 5`,
+		`raid5_http_request_duration_seconds_count{method="GET"} 2`,
+		`raid5_http_request_duration_seconds_bucket{method="GET",le="+Inf"} 2`,
+		"# TYPE raid5_http_request_duration_seconds histogram",
+		"raid5_degraded_reads_total 1",
+		`raid5_member_state{member="0",state="online"} 1`,
+		`raid5_member_state{member="0",state="failed"} 0`,
+		`raid5_member_errors_total{member="0"} 0`,
+		fmt.Sprintf("raid5_member_info{member=\"1\",dir=%q} 1", array.Dirs()[raid5.MEMBER_D2]),
+		"# TYPE raid5_member_free_bytes gauge",
+	} {
+		if !lines[want] {
+			t.Errorf("metrics are missing %s", want)
+		}
+	}
+	//the metrics themselves aren't counted
+	if lines[`raid5_http_requests_total{method="GET",code="200"} 2`] {
+		t.Errorf("the GET of /metrics was counted")
+	}
+}
+
+//start a PUT to url that sends half of content and then waits, the
+//rest goes when finish is closed.  it returns once the server says the
+//request has started, the result shows up on the returned channel.
+func slowPut(t *testing.T, url string, started chan struct{}, content []byte, finish chan struct{}) chan error {
+	r, w := io.Pipe()
+	req, err := http.NewRequest(http.MethodPut, url, r)
+	if err != nil {
+		t.Fatalf("bad request: %v", err)
+	}
+	req.ContentLength = int64(len(content))
+	result := make(chan error, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("PUT failed: %s", resp.Status)
+			}
+		}
+		result <- err
+	}()
+	go func() {
+		w.Write(content[:len(content)/2])
+		<-finish
+		w.Write(content[len(content)/2:])
+		w.Close()
+	}()
+	<-started
+	return result
+}
+
+func TestShutdownDrainsWrites(t *testing.T) {
+	setupServer(t)
+	started := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		started <- struct{}{}
+		routes().ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+	content := bytes.Repeat([]byte("patience "), raid5.BLOCK_SIZE)
+
+	//a write that finishes in time is kept
+	finish := make(chan struct{})
+	put := slowPut(t, srv.URL+"/raid5/in time", started, content, finish)
+	stopped := make(chan struct{})
+	go func() {
+		stopServers(5*time.Second, srv.Config)
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatalf("shut down with a write in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if resp, err := http.Get(srv.URL + "/raid5/"); err == nil {
+		resp.Body.Close()
+		t.Errorf("new requests should be turned away while shutting down")
+	}
+	close(finish)
+	if err := <-put; err != nil {
+		t.Errorf("write in progress failed: %v", err)
+	}
+	<-stopped
+	writes.Wait()
+	obj, err := array.OpenFile("in time")
+	if err != nil {
+		t.Fatalf("write in progress wasn't kept: %v", err)
+	}
+	got := make([]byte, obj.Size())
+	obj.ReadFile(got, 0)
+	obj.Close()
+	if !bytes.Equal(got, content) {
+		t.Errorf("wrong content for the write in progress")
+	}
+}
+
+func TestShutdownRollsBackLateWrites(t *testing.T) {
+	setupServer(t)
+	started := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		started <- struct{}{}
+		routes().ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+	content := bytes.Repeat([]byte("too late "), raid5.BLOCK_SIZE)
+
+	finish := make(chan struct{})
+	put := slowPut(t, srv.URL+"/raid5/too late", started, content, finish)
+	stopServers(20*time.Millisecond, srv.Config)
+	//the rest of it goes nowhere
+	close(finish)
+	if err := <-put; err == nil {
+		t.Errorf("expected the write that didn't finish in time to fail")
+	}
+	writes.Wait()
+	if _, err := array.OpenFile("too late"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the write to be rolled back, got %v", err)
+	}
+}
+
+func TestStoredHeaders(t *testing.T) {
+	srv := setupServer(t)
+	content := bytes.Repeat([]byte("pretend this is gzipped "), 100)
+	header := http.Header{}
+	header.Set("Content-Type", "text/x-test")
+	header.Set("Content-Encoding", "gzip")
+	header.Set("Cache-Control", "max-age=60")
+	header.Set(META_PREFIX+"Owner", "somebody")
+	header.Set("X-Not-Kept", "nope")
+	if err := client.New(srv.URL).PutHeader(context.Background(), "typed", bytes.NewReader(content), header); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+
+	//without this the transport would take the encoding off
+	identity := http.Header{"Accept-Encoding": {"identity"}}
+	ranged := http.Header{"Accept-Encoding": {"identity"}, "Range": {"bytes=10-19"}}
+	for _, req := range []struct {
+		method string
+		header http.Header
+		code   int
+		want   []byte
+	}{
+		{http.MethodGet, identity, http.StatusOK, content},
+		{http.MethodHead, identity, http.StatusOK, content},
+		{http.MethodGet, ranged, http.StatusPartialContent, content[10:20]},
+	} {
+		resp, body := do(t, req.method, srv.URL+"/raid5/typed", req.header)
+		if resp.StatusCode != req.code {
+			t.Errorf("%s %v: expected %d, got %s", req.method, req.header, req.code, resp.Status)
+		}
+		for _, key := range []string{"Content-Type", "Content-Encoding", "Cache-Control", META_PREFIX + "Owner"} {
+			if got := resp.Header.Get(key); got != header.Get(key) {
+				t.Errorf("%s %v: expected %s %q, got %q", req.method, req.header, key, header.Get(key), got)
+			}
+		}
+		if resp.Header.Get("X-Not-Kept") != "" {
+			t.Errorf("%s %v: a header that isn't ours was kept", req.method, req.header)
+		}
+		if resp.ContentLength != int64(len(req.want)) {
+			t.Errorf("%s %v: expected a length of %d, got %d", req.method, req.header, len(req.want), resp.ContentLength)
+		}
+		if req.method == http.MethodGet && !bytes.Equal(body, req.want) {
+			t.Errorf("%s %v: wrong content", req.method, req.header)
+		}
+	}
+
+	//an object without any gets the defaults
+	if err := client.New(srv.URL).Put(context.Background(), "plain", strings.NewReader("plain text")); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	resp, _ := do(t, http.MethodGet, srv.URL+"/raid5/plain", identity)
+	if resp.Header.Get("Content-Encoding") != "" || resp.Header.Get("Cache-Control") != "" ||
+		!strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("wrong headers for an object stored without any: %v", resp.Header)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"github.com/iansmith/raid5"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//upper bounds of the request latency histogram buckets, in seconds
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+//what we know about one kind of request (GET, PUT...)
+type requestMetrics struct {
+	//by status code
+	counts   map[int]uint64
+	bytesIn  uint64
+	bytesOut uint64
+	//not cumulative, that's done when they are written out
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+//metrics about the HTTP side of things, the array keeps its own
+type serverMetrics struct {
+	mu       sync.Mutex
+	requests map[string]*requestMetrics
+}
+
+var metrics = &serverMetrics{requests: make(map[string]*requestMetrics)}
+
+func (self *serverMetrics) record(method string, code int, in, out int64, elapsed time.Duration) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	m, ok := self.requests[method]
+	if !ok {
+		m = &requestMetrics{counts: make(map[int]uint64), buckets: make([]uint64, len(latencyBuckets))}
+		self.requests[method] = m
+	}
+	m.counts[code]++
+	m.bytesIn += uint64(in)
+	m.bytesOut += uint64(out)
+	secs := elapsed.Seconds()
+	for i, bound := range latencyBuckets {
+		if secs <= bound {
+			m.buckets[i]++
+			break
+		}
+	}
+	m.sum += secs
+	m.count++
+}
+
+//keeps track of what a handler sends back
+type recordingWriter struct {
+	http.ResponseWriter
+	code  int
+	bytes int64
+}
+
+func (self *recordingWriter) WriteHeader(code int) {
+	self.code = code
+	self.ResponseWriter.WriteHeader(code)
+}
+
+func (self *recordingWriter) Write(b []byte) (int, error) {
+	n, err := self.ResponseWriter.Write(b)
+	self.bytes += int64(n)
+	return n, err
+}
+
+//keeps track of how much of the body a handler read
+type countingReader struct {
+	io.ReadCloser
+	bytes int64
+}
+
+func (self *countingReader) Read(b []byte) (int, error) {
+	n, err := self.ReadCloser.Read(b)
+	self.bytes += int64(n)
+	return n, err
+}
+
+//instrument wraps a handler so its requests show up in /metrics
+func instrument(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rw := &recordingWriter{ResponseWriter: w, code: http.StatusOK}
+		body := &countingReader{ReadCloser: req.Body}
+		req.Body = body
+		h(rw, req)
+		metrics.record(req.Method, rw.code, body.bytes, rw.bytes, time.Since(start))
+	}
+}
+
+//the prometheus text format, see
+//https://prometheus.io/docs/instrumenting/exposition_formats/
+func writeMetric(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func serveMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	var out strings.Builder
+
+	metrics.mu.Lock()
+	methods := []string{}
+	for method := range metrics.requests {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	writeMetric(&out, "raid5_http_requests_total", "counter", "HTTP requests by method and status code.")
+	for _, method := range methods {
+		m := metrics.requests[method]
+		codes := []int{}
+		for code := range m.counts {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Fprintf(&out, "raid5_http_requests_total{method=%q,code=\"%d\"} %d\n", method, code, m.counts[code])
+		}
+	}
+	writeMetric(&out, "raid5_http_received_bytes_total", "counter", "Bytes of request bodies read.")
+	for _, method := range methods {
+		fmt.Fprintf(&out, "raid5_http_received_bytes_total{method=%q} %d\n", method, metrics.requests[method].bytesIn)
+	}
+	writeMetric(&out, "raid5_http_sent_bytes_total", "counter", "Bytes of response bodies written.")
+	for _, method := range methods {
+		fmt.Fprintf(&out, "raid5_http_sent_bytes_total{method=%q} %d\n", method, metrics.requests[method].bytesOut)
+	}
+	writeMetric(&out, "raid5_http_request_duration_seconds", "histogram", "Time taken to answer HTTP requests.")
+	for _, method := range methods {
+		m := metrics.requests[method]
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += m.buckets[i]
+			fmt.Fprintf(&out, "raid5_http_request_duration_seconds_bucket{method=%q,le=\"%g\"} %d\n", method, bound, cumulative)
+		}
+		fmt.Fprintf(&out, "raid5_http_request_duration_seconds_bucket{method=%q,le=\"+Inf\"} %d\n", method, m.count)
+		fmt.Fprintf(&out, "raid5_http_request_duration_seconds_sum{method=%q} %g\n", method, m.sum)
+		fmt.Fprintf(&out, "raid5_http_request_duration_seconds_count{method=%q} %d\n", method, m.count)
+	}
+	metrics.mu.Unlock()
+
+	stats := array.Stats()
+	writeMetric(&out, "raid5_degraded_reads_total", "counter", "Reads that had to reconstruct data from parity.")
+	fmt.Fprintf(&out, "raid5_degraded_reads_total %d\n", stats.DegradedReads)
+	writeMetric(&out, "raid5_reconstructed_bytes_total", "counter", "Bytes reconstructed from parity by reads and rebuilds.")
+	fmt.Fprintf(&out, "raid5_reconstructed_bytes_total %d\n", stats.ReconstructedBytes)
+	writeMetric(&out, "raid5_checksum_failures_total", "counter", "Reads whose data did not match the stored hash.")
+	fmt.Fprintf(&out, "raid5_checksum_failures_total %d\n", stats.ChecksumFailures)
+
+	writeMetric(&out, "raid5_hedged_reads_total", "counter", "Stripes read from parity because a data member was slow.")
+	fmt.Fprintf(&out, "raid5_hedged_reads_total %d\n", stats.HedgedReads)
+
+	//everything per member is by slot, so a spare taking over carries
+	//on the same series.  which directory is in the slot is in
+	//raid5_member_info.
+	members := array.Members()
+	writeMetric(&out, "raid5_member_info", "gauge", "Always 1, dir is the directory currently in the member slot.")
+	for i, m := range members {
+		fmt.Fprintf(&out, "raid5_member_info{member=\"%d\",dir=%q} 1\n", i, m.Dir)
+	}
+	writeMetric(&out, "raid5_member_errors_total", "counter", "I/O errors on each member.")
+	for i := range members {
+		fmt.Fprintf(&out, "raid5_member_errors_total{member=\"%d\"} %d\n", i, stats.MemberErrors[i])
+	}
+	writeMetric(&out, "raid5_member_reads_total", "counter", "Reads from each member.")
+	for i := range members {
+		fmt.Fprintf(&out, "raid5_member_reads_total{member=\"%d\"} %d\n", i, stats.MemberReads[i])
+	}
+	writeMetric(&out, "raid5_member_read_seconds_total", "counter", "Time spent reading from each member, not counting time waiting in line.")
+	for i := range members {
+		fmt.Fprintf(&out, "raid5_member_read_seconds_total{member=\"%d\"} %g\n", i, stats.MemberReadTime[i].Seconds())
+	}
+	writeMetric(&out, "raid5_member_stale_legs_total", "counter", "Objects opened where a member disagreed with the other two.")
+	for i := range members {
+		fmt.Fprintf(&out, "raid5_member_stale_legs_total{member=\"%d\"} %d\n", i, stats.StaleLegs[i])
+	}
+	//all of the states, so the one a member has left goes to 0
+	writeMetric(&out, "raid5_member_state", "gauge", "1 for the state each member is in, 0 for the others.")
+	for i, m := range members {
+		for state := raid5.MEMBER_ONLINE; state <= raid5.MEMBER_REBUILDING; state++ {
+			current := 0
+			if m.State == state {
+				current = 1
+			}
+			fmt.Fprintf(&out, "raid5_member_state{member=\"%d\",state=%q} %d\n", i, state, current)
+		}
+	}
+	writeMetric(&out, "raid5_member_free_bytes", "gauge", "Bytes available on each member's disk.")
+	for i, m := range members {
+		fmt.Fprintf(&out, "raid5_member_free_bytes{member=\"%d\"} %d\n", i, m.Free)
+	}
+	writeMetric(&out, "raid5_member_size_bytes", "gauge", "Total size of each member's disk.")
+	for i, m := range members {
+		fmt.Fprintf(&out, "raid5_member_size_bytes{member=\"%d\"} %d\n", i, m.Size)
+	}
+	io.WriteString(w, out.String())
+}